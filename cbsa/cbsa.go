@@ -0,0 +1,218 @@
+// Package cbsa reads the OMB delineations of core based statistical areas (CBSAs) -- the metropolitan areas and
+// their divisions that Freddie's msaD field codes.  OMB revises the delineations every few years, so a code that
+// is legal in one vintage may not be in another.
+//
+// A delineation file is the Census Bureau's "list 1" (CBSAs, metropolitan divisions, CSAs and their counties)
+// saved as CSV.  The title rows above the header and the notes below the data are skipped.  The columns used are
+// found by their headers:
+//
+//	CBSA Code, CBSA Title, Metropolitan/Micropolitan Statistical Area, Metropolitan Division Code,
+//	Metropolitan Division Title and, optionally, CSA Code and CSA Title.
+//
+// The vintage of a file is the year in its name, e.g. list1_2023.csv is vintage 2023.
+package cbsa
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Builtin is the vintage reported when no delineation file is used: msaD is checked against the list built into
+// the static package.
+const Builtin = "builtin"
+
+// Area is a metropolitan area or division, as coded in msaD.  Micropolitan areas are not coded by Freddie.
+type Area struct {
+	Code     string // Code is the division code if the CBSA is divided, the CBSA code if not
+	Name     string // Name is the title of the division or CBSA
+	CBSA     string // CBSA is the code of the metropolitan area
+	CBSAName string // CBSAName is the title of the metropolitan area
+	CSA      string // CSA is the code of the combined statistical area, if the CBSA is in one
+	CSAName  string // CSAName is the title of the combined statistical area
+}
+
+// Delineation is the set of areas of a vintage
+type Delineation struct {
+	Vintage string           // Vintage is the year of the delineation, e.g. 2023
+	File    string           // File is the file it was read from
+	Areas   map[string]*Area // Areas are the areas keyed by their Code
+}
+
+// Levels returns the sorted codes of the areas of d
+func (d *Delineation) Levels() []string {
+	lvls := make([]string, 0, len(d.Areas))
+	for code := range d.Areas {
+		lvls = append(lvls, code)
+	}
+	sort.Strings(lvls)
+	return lvls
+}
+
+// Get returns the area with code, or nil if there is none
+func (d *Delineation) Get(code string) *Area {
+	if d == nil {
+		return nil
+	}
+	return d.Areas[code]
+}
+
+// vintageRe finds the vintage in a file name
+var vintageRe = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{2})(?:[^0-9]|$)`)
+
+// Vintages returns the delineation files (*.csv) in dir keyed by their vintage.  It is an error for two files to
+// have the same vintage.
+func Vintages(dir string) (map[string]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, name := range names {
+		m := vintageRe.FindStringSubmatch(filepath.Base(name))
+		if m == nil {
+			continue
+		}
+		if f, ok := files[m[1]]; ok {
+			return nil, fmt.Errorf("cbsa: files %s and %s are both vintage %s", f, name, m[1])
+		}
+		files[m[1]] = name
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("cbsa: no delineation files in %s", dir)
+	}
+	return files, nil
+}
+
+// Find returns the delineation in dir of vintage.  If vintage is empty, the latest vintage not after asOf is used
+// -- the one in force when the data was released.  If asOf is zero, the latest vintage is used.
+func Find(dir string, vintage string, asOf time.Time) (*Delineation, error) {
+	files, err := Vintages(dir)
+	if err != nil {
+		return nil, err
+	}
+	if vintage == "" {
+		for v := range files {
+			if (asOf.IsZero() || v <= fmt.Sprintf("%d", asOf.Year())) && v > vintage {
+				vintage = v
+			}
+		}
+		if vintage == "" {
+			return nil, fmt.Errorf("cbsa: no delineation in %s is in force by %s", dir, asOf.Format("2006-01-02"))
+		}
+	}
+	name, ok := files[vintage]
+	if !ok {
+		return nil, fmt.Errorf("cbsa: no delineation of vintage %s in %s", vintage, dir)
+	}
+	d, err := Read(name)
+	if err != nil {
+		return nil, err
+	}
+	d.Vintage = vintage
+	return d, nil
+}
+
+// the columns of a delineation file
+const (
+	colCBSA      = "cbsa code"
+	colCBSAName  = "cbsa title"
+	colType      = "metropolitan/micropolitan statistical area"
+	colDiv       = "metropolitan division code"
+	colDivName   = "metropolitan division title"
+	colCSA       = "csa code"
+	colCSAName   = "csa title"
+	metropolitan = "metropolitan statistical area"
+)
+
+// Read reads the delineation file name.  The vintage is taken from the name, if it has one.
+func Read(name string) (*Delineation, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	d := &Delineation{File: name, Areas: make(map[string]*Area)}
+	if m := vintageRe.FindStringSubmatch(filepath.Base(name)); m != nil {
+		d.Vintage = m[1]
+	}
+
+	rdr := csv.NewReader(f)
+	rdr.FieldsPerRecord, rdr.LazyQuotes = -1, true
+	var cols map[string]int
+	for {
+		row, e := rdr.Read()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, fmt.Errorf("cbsa: %s: %v", name, e)
+		}
+		// the title rows come before the header
+		if cols == nil {
+			if cols = header(row); cols != nil {
+				for _, c := range []string{colCBSA, colCBSAName, colType, colDiv, colDivName} {
+					if _, ok := cols[c]; !ok {
+						return nil, fmt.Errorf("cbsa: %s: no %s column", name, c)
+					}
+				}
+			}
+			continue
+		}
+		get := func(col string) string {
+			if ind, ok := cols[col]; ok && ind < len(row) {
+				return strings.TrimSpace(row[ind])
+			}
+			return ""
+		}
+		// the notes after the data have no CBSA code
+		if !isCode(get(colCBSA)) || !strings.EqualFold(get(colType), metropolitan) {
+			continue
+		}
+		a := &Area{Code: get(colCBSA), Name: get(colCBSAName), CBSA: get(colCBSA), CBSAName: get(colCBSAName),
+			CSA: get(colCSA), CSAName: get(colCSAName)}
+		if div := get(colDiv); isCode(div) {
+			a.Code, a.Name = div, get(colDivName)
+		}
+		d.Areas[a.Code] = a
+	}
+	if cols == nil {
+		return nil, fmt.Errorf("cbsa: %s: no header row with a %s column", name, colCBSA)
+	}
+	if len(d.Areas) == 0 {
+		return nil, fmt.Errorf("cbsa: %s: no metropolitan areas", name)
+	}
+	return d, nil
+}
+
+// header returns the columns of row keyed by their lower-case names if row is the header, nil if not
+func header(row []string) map[string]int {
+	cols := make(map[string]int)
+	for ind, c := range row {
+		cols[strings.ToLower(strings.TrimSpace(c))] = ind
+	}
+	if _, ok := cols[colCBSA]; !ok {
+		return nil
+	}
+	return cols
+}
+
+// isCode returns true if c is a 5-digit code
+func isCode(c string) bool {
+	if len(c) != 5 {
+		return false
+	}
+	for _, r := range c {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package cbsa
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const list1 = `List 1. CORE BASED STATISTICAL AREAS (CBSAs) AND COMBINED STATISTICAL AREAS (CSAs),,,,,,,,,,,
+,,,,,,,,,,,
+CBSA Code,Metropolitan Division Code,CSA Code,CBSA Title,Metropolitan/Micropolitan Statistical Area,Metropolitan Division Title,CSA Title,County/County Equivalent,State Name,FIPS State Code,FIPS County Code,Central/Outlying County
+10180,,101,"Abilene, TX",Metropolitan Statistical Area,,"Abilene-Sweetwater, TX",Callahan County,Texas,48,059,Outlying
+10180,,101,"Abilene, TX",Metropolitan Statistical Area,,"Abilene-Sweetwater, TX",Jones County,Texas,48,253,Outlying
+10100,,,"Aberdeen, SD",Micropolitan Statistical Area,,,Brown County,South Dakota,46,013,Central
+35620,35614,408,"New York-Newark-Jersey City, NY-NJ-PA",Metropolitan Statistical Area,"New York-Jersey City-White Plains, NY-NJ",,Bronx County,New York,36,005,Central
+,,,,,,,,,,,
+"Note: The 2020 Standards for Delineating Core Based Statistical Areas...",,,,,,,,,,,
+`
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"list1_2020.csv", "list1_2023.csv"} {
+		if e := os.WriteFile(filepath.Join(dir, name), []byte(list1), 0600); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	d, err := Find(dir, "", time.Date(2022, 6, 30, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Vintage != "2020" {
+		t.Errorf("vintage: got %s, want 2020", d.Vintage)
+	}
+	if lvls := d.Levels(); !reflect.DeepEqual(lvls, []string{"10180", "35614"}) {
+		t.Errorf("levels: got %v", lvls)
+	}
+	want := &Area{Code: "35614", Name: "New York-Jersey City-White Plains, NY-NJ", CBSA: "35620",
+		CBSAName: "New York-Newark-Jersey City, NY-NJ-PA", CSA: "408"}
+	if a := d.Get("35614"); !reflect.DeepEqual(a, want) {
+		t.Errorf("35614: got %+v", a)
+	}
+
+	if d, err = Find(dir, "", time.Time{}); err != nil || d.Vintage != "2023" {
+		t.Errorf("latest: got %v, %v", d, err)
+	}
+	if _, e := Find(dir, "2018", time.Time{}); e == nil {
+		t.Error("expected error for missing vintage")
+	}
+	if _, e := Find(dir, "", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)); e == nil {
+		t.Error("expected error for no vintage in force")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/invertedv/freddie/joined"
+	"github.com/invertedv/freddie/manifest"
+	"github.com/invertedv/freddie/monthly"
+	"github.com/invertedv/freddie/pipeline"
+	"github.com/invertedv/freddie/source"
+	"github.com/invertedv/freddie/spec"
+	"github.com/invertedv/freddie/static"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// runVerify is the verify command: it compares the loans of each quarter in -table to the source files in -dir.
+func runVerify(args []string) (err error) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	conn := connFlags(fs)
+	srcDir := fs.String("dir", "", "string")
+	table := fs.String("table", "", "string")
+	from := fs.String("from", "", "string")
+	to := fs.String("to", "", "string")
+	quarters := fs.String("quarters", "", "string")
+	if e := parse(fs, conn, args); e != nil {
+		return e
+	}
+
+	con, err := conn.connect(1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := con.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	fileList, keys, err := pipeline.New(*srcDir, pipeline.WithQuarters(*from, *to, *quarters), pipeline.WithLogger(lg)).Files()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "quarter\tstandard\tfile loans\ttable loans\tfile months\ttable months\tmissing loans\t")
+	bad := make([]string, 0)
+	for _, k := range keys {
+		standard := joined.Standard(fileList[k].Static)
+		nStatic, e := source.CountLines(ctx, fileList[k].Static)
+		if e != nil {
+			return e
+		}
+		nMonthly, e := source.CountLines(ctx, fileList[k].Monthly)
+		if e != nil {
+			return e
+		}
+		loans, months, e := joined.QuarterCounts(*table, k, standard, con)
+		if e != nil {
+			return e
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t\n", k, standard, nStatic, loans, nMonthly, months, nStatic-loans)
+		if loans == 0 || loans > nStatic || months > nMonthly {
+			bad = append(bad, k)
+		}
+	}
+	if e := tw.Flush(); e != nil {
+		return e
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("quarters missing from %s or with more rows than their files: %s", *table, strings.Join(bad, ","))
+	}
+	return nil
+}
+
+// runDescribe is the describe command: it prints the fields of -table with their descriptions.
+func runDescribe(args []string) (err error) {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	conn := connFlags(fs)
+	table := fs.String("table", "", "string")
+	if e := parse(fs, conn, args); e != nil {
+		return e
+	}
+
+	con, err := conn.connect(1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := con.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	// table may or may not include the database
+	db, tbl := "currentDatabase()", "'"+*table+"'"
+	if ind := strings.Index(*table, "."); ind > 0 {
+		db, tbl = "'"+(*table)[:ind]+"'", "'"+(*table)[ind+1:]+"'"
+	}
+	qry := fmt.Sprintf("SELECT name, type, comment FROM system.columns WHERE database = %s AND table = %s ORDER BY position",
+		db, tbl)
+	rows, err := con.Query(qry)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	n := 0
+	for rows.Next() {
+		var name, typ, comment string
+		if e := rows.Scan(&name, &typ, &comment); e != nil {
+			return e
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", name, typ, comment)
+		n++
+	}
+	if e := rows.Err(); e != nil {
+		return e
+	}
+	if n == 0 {
+		return fmt.Errorf("table %s not found", *table)
+	}
+	return tw.Flush()
+}
+
+// runDropQuarter is the drop-quarter command: it deletes the loans of a quarter from -table and its manifest entry.
+func runDropQuarter(args []string) (err error) {
+	fs := flag.NewFlagSet("drop-quarter", flag.ExitOnError)
+	conn := connFlags(fs)
+	table := fs.String("table", "", "string")
+	manifestTable := fs.String("manifest", "", "string")
+	quarter := fs.String("quarter", "", "string")
+	standard := fs.String("standard", "Y", "string")
+	if e := parse(fs, conn, args); e != nil {
+		return e
+	}
+	if !pipeline.QuarterRe.MatchString(*quarter) {
+		return fmt.Errorf("bad quarter %s, need form CCYYQn or, for the sample dataset, CCYY", *quarter)
+	}
+	if *manifestTable == "" {
+		*manifestTable = *table + "_manifest"
+	}
+	*standard = strings.ToUpper(*standard)
+
+	con, err := conn.connect(1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := con.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if e := joined.Delete(*table, *quarter, *standard, con); e != nil {
+		return e
+	}
+	// the manifest has one entry per quarter, so only drop it if it is for the loans just deleted
+	if ok, e := joined.Exists(*manifestTable, con); e != nil || !ok {
+		return e
+	}
+	entries, err := manifest.Get(*manifestTable, *table, con)
+	if err != nil {
+		return err
+	}
+	if v, ok := entries[*quarter]; ok && joined.Standard(v.FileStatic) == *standard {
+		if e := manifest.Drop(*manifestTable, *table, *quarter, con); e != nil {
+			return e
+		}
+	}
+	fmt.Printf("Dropped quarter %s (standard=%s) from %s\n", *quarter, *standard, *table)
+	return nil
+}
+
+// runStatus is the status command: it shows the quarters loaded into -table, from the manifest.
+func runStatus(args []string) (err error) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	conn := connFlags(fs)
+	table := fs.String("table", "", "string")
+	manifestTable := fs.String("manifest", "", "string")
+	if e := parse(fs, conn, args); e != nil {
+		return e
+	}
+	if *manifestTable == "" {
+		*manifestTable = *table + "_manifest"
+	}
+
+	con, err := conn.connect(1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := con.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	if ok, e := joined.Exists(*manifestTable, con); e != nil || !ok {
+		if e == nil {
+			e = fmt.Errorf("manifest %s not found", *manifestTable)
+		}
+		return e
+	}
+	entries, err := manifest.Get(*manifestTable, *table, con)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "quarter\tstatus\tloans\tfinished\tstatic file\tmonthly file")
+	counts := make(map[string]int)
+	var nLoans int64
+	for _, k := range keys {
+		v := entries[k]
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", k, v.Status, v.NLoans, v.Finished.Format("2006/1/2 15:04"),
+			v.FileStatic, v.FileMonthly)
+		counts[v.Status]++
+		nLoans += v.NLoans
+	}
+	if e := tw.Flush(); e != nil {
+		return e
+	}
+	fmt.Printf("%s: %d quarters (%d done, %d failed, %d started), %d loans\n", *table, len(keys),
+		counts[manifest.Done], counts[manifest.Failed], counts[manifest.Started], nLoans)
+	return nil
+}
+
+// runSpec is the spec command: it prints the built-in validation spec as YAML, to start a spec file from.
+func runSpec(args []string) error {
+	fs := flag.NewFlagSet("spec", flag.ExitOnError)
+	conn := connFlags(fs)
+	if e := parse(fs, conn, args); e != nil {
+		return e
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if e := enc.Encode(&spec.Spec{Version: spec.Builtin, Static: static.Spec(), Monthly: monthly.Spec()}); e != nil {
+		return e
+	}
+	return enc.Close()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/invertedv/freddie/logger"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+)
+
+// sources of a flag value, in increasing order of precedence
+const (
+	fromDefault = 0 + iota
+	fromConfig
+	fromEnv
+	fromFlag
+)
+
+// envPrefix starts the name of the environment variable for each flag
+const envPrefix = "FREDDIE_"
+
+// envName returns the environment variable for flag name, e.g. FREDDIE_QUARTERS_PARALLEL for -quarters-parallel.
+// The ClickHouse connection flags have a CH_ prefix, e.g. FREDDIE_CH_PASSWORD for -password.
+func envName(name string) string {
+	switch name {
+	case "host", "user", "password", "password-file":
+		name = "ch-" + name
+	}
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// parse parses the command-line args into fs and then fills in the flags not on the command line from the
+// environment and then the config file.  The precedence is
+//
+//	command line > environment > config file > default
+//
+// The config file is given by -config or FREDDIE_CONFIG.  Finally, the password is read from the password file if
+// that comes from a source with higher precedence than the password itself (see password).
+func parse(fs *flag.FlagSet, conn *connOpts, args []string) error {
+	if e := fs.Parse(args); e != nil {
+		return e
+	}
+	src := make(map[string]int)
+	fs.Visit(func(f *flag.Flag) { src[f.Name] = fromFlag })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && src[f.Name] == fromDefault && err == nil {
+			if err = fs.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("environment variable %s: %v", envName(f.Name), err)
+			}
+			src[f.Name] = fromEnv
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if *conn.config != "" {
+		cfg, e := readConfig(*conn.config)
+		if e != nil {
+			return e
+		}
+		// the config file may hold flags for other commands, which are ignored
+		fs.VisitAll(func(f *flag.Flag) {
+			if v, ok := cfg[f.Name]; ok && src[f.Name] == fromDefault && err == nil {
+				if err = fs.Set(f.Name, v); err != nil {
+					err = fmt.Errorf("config file %s: %s: %v", *conn.config, f.Name, err)
+				}
+				src[f.Name] = fromConfig
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if lg, err = logger.New(os.Stderr, *conn.logFormat); err != nil {
+		return err
+	}
+	return conn.password(src)
+}
+
+// password reads the password from -password-file if its source has higher precedence than -password.  If they
+// come from the same source, -password wins.
+func (c *connOpts) password(src map[string]int) error {
+	if *c.passwordFile == "" || src["password-file"] <= src["password"] {
+		return nil
+	}
+	b, err := os.ReadFile(*c.passwordFile)
+	if err != nil {
+		return err
+	}
+	// files usually end with a newline, which is not part of the password
+	*c.pass = strings.TrimRight(string(b), "\r\n")
+	return nil
+}
+
+// readConfig reads the YAML config file name.  The file is a map of flag names (without the dash) to values.
+// A list is joined with commas, so -quarters can be given as a list.
+func readConfig(name string) (map[string]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]interface{})
+	if e := yaml.Unmarshal(b, &raw); e != nil {
+		return nil, fmt.Errorf("config file %s: %v", name, e)
+	}
+
+	cfg := make(map[string]string)
+	for k, v := range raw {
+		switch x := v.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			vals := make([]string, len(x))
+			for ind, xv := range x {
+				vals[ind] = fmt.Sprint(xv)
+			}
+			cfg[k] = strings.Join(vals, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("config file %s: %s must be a value or a list", name, k)
+		default:
+			cfg[k] = fmt.Sprint(x)
+		}
+	}
+	return cfg, nil
+}
+
+// flagValues returns the values of the flags of fs, except the password
+func flagValues(fs *flag.FlagSet) map[string]string {
+	vals := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != "password" {
+			vals[f.Name] = f.Value.String()
+		}
+	})
+	return vals
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "freddie.yaml")
+	cfg := "host: 10.0.0.1\nuser: loader\nmemory: 1000\ntable: cfg.loans\nquarters: [2010Q1, 2010Q2]\nnotAFlag: 1\n"
+	if e := os.WriteFile(cfgFile, []byte(cfg), 0600); e != nil {
+		t.Fatal(e)
+	}
+	pwFile := filepath.Join(dir, "pw")
+	if e := os.WriteFile(pwFile, []byte("fromfile\n"), 0600); e != nil {
+		t.Fatal(e)
+	}
+
+	t.Setenv("FREDDIE_CONFIG", cfgFile)
+	t.Setenv("FREDDIE_CH_USER", "envuser")
+	t.Setenv("FREDDIE_CH_PASSWORD_FILE", pwFile)
+	t.Setenv("FREDDIE_TABLE", "env.loans")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	conn := connFlags(fs)
+	table := fs.String("table", "", "string")
+	quarters := fs.String("quarters", "", "string")
+	if e := parse(fs, conn, []string{"-table", "flag.loans"}); e != nil {
+		t.Fatal(e)
+	}
+
+	checks := []struct{ name, got, want string }{
+		{"host (config)", *conn.host, "10.0.0.1"},
+		{"user (env over config)", *conn.user, "envuser"},
+		{"table (flag over env)", *table, "flag.loans"},
+		{"quarters (config list)", *quarters, "2010Q1,2010Q2"},
+		{"password (file)", *conn.pass, "fromfile"},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, c.got, c.want)
+		}
+	}
+	if *conn.memory != 1000 {
+		t.Errorf("memory: got %d, want 1000", *conn.memory)
+	}
+
+	// a password from a higher-precedence source beats the password file
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	conn = connFlags(fs)
+	if e := parse(fs, conn, []string{"-password", "fromflag"}); e != nil {
+		t.Fatal(e)
+	}
+	if *conn.pass != "fromflag" {
+		t.Errorf("password: got %q, want fromflag", *conn.pass)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/invertedv/freddie/pipeline"
+	"os"
+	"text/tabwriter"
+)
+
+// dryRun reads and validates the files of the quarters of p without ClickHouse.  For each quarter, the
+// pass/default/fail counts of each field are printed, followed by a summary of the row counts.
+func dryRun(ctx context.Context, p *pipeline.Pipeline) error {
+	results, err := p.DryRun(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		fmt.Printf("\nQuarter %s static: %s\n", r.Quarter, r.Files.Static)
+		if e := r.StaticQA.Write(os.Stdout); e != nil {
+			return e
+		}
+		fmt.Printf("\nQuarter %s monthly: %s\n", r.Quarter, r.Files.Monthly)
+		if e := r.MonthlyQA.Write(os.Stdout); e != nil {
+			return e
+		}
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "quarter\tstatic rows\tmonthly rows\t")
+	for _, r := range results {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t\n", r.Quarter, r.StaticQA.Rows, r.MonthlyQA.Rows)
+	}
+	return tw.Flush()
+}
@@ -8,40 +8,249 @@
 //   - New fields created are:
 //   - vintage (e.g. 2010Q2)
 //   - standard - Y/N flag, Y=standard process loan
+//   - sample - Y/N flag, Y=loan from the sample dataset
 //   - loan age based on first pay date
 //   - numeric dq field
 //   - reo flag
 //   - property value at origination
+//   - msaName, cbsa and cbsaName, the names of the msa/division and its CBSA, with -cbsa
+//   - zip3State, the majority state of the 3 digit zip, with -zip3
 //   - file names from which the loan was loaded
+//   - runId, the id of the run that loaded the loan
 //   - QA results. There are three sets of fields:
-//   - The nested table qa that has two arrays:
+//   - The nested table qa that has three arrays:
 //   - field.  The name of a field that has validation issues.
-//   - cntFail. The number of months for which this field failed qa.  For static fields, this value will
-//     be 1.
+//   - reason.  The reason code: why the field failed (see below).
+//   - cntFail. The number of months for which this field failed qa for this reason.  For static fields, this
+//     value will be 1.
 //   - allFail.  An array of field names which failed for qa.  For monthly fields, this means the field failed for all months.
 //
-// command-line parameters:
+// The utility has several commands:
+//
+//	freddie load <flags>           load the quarters in -dir into -table.
+//	freddie verify <flags>         compare the loans of each quarter in -table against the source files in -dir.
+//	freddie describe <flags>       print the fields of -table with their descriptions.
+//	freddie drop-quarter <flags>   delete the loans of a quarter from -table.
+//	freddie status <flags>         show the quarters loaded into -table, from the manifest.
+//	freddie reconcile <flags>      compare the loans in -table with Freddie's published figures.
+//	freddie spec <flags>           print the built-in validation spec as YAML.
+//
+// If no command is given (the first argument is a flag), the command is load.
+//
+// All the commands take the ClickHouse connection flags:
 //
 //	-host  ClickHouse IP address. Default: 127.0.0.1.
 //	-user  ClickHouse user. Default: default
 //	-password ClickHouse password for user. Default: <empty>.
+//	-memory max memory usage by ClickHouse.  Default: 40000000000.
+//	-groupby max_bytes_before_external_groupby ClickHouse parameter. Default: 20000000000.
+//	-password-file file holding the ClickHouse password, so it stays out of ps and the shell history.
+//	-config YAML file with flag values (see below).
+//	-log-format format of the log: text or json (one JSON object per line). Default: text.
+//
+// Each flag can also be set by an environment variable: FREDDIE_ followed by the flag name in upper case with
+// dashes replaced by underscores, e.g. FREDDIE_QUARTERS_PARALLEL.  The connection flags -host, -user, -password
+// and -password-file use FREDDIE_CH_, e.g. FREDDIE_CH_PASSWORD.  The config file is a map of flag names to values,
+// e.g.
+//
+//	host: 10.0.0.5
+//	user: loader
+//	password-file: /home/loader/.chpass
+//	memory: 80000000000
+//	quarters: [2015Q3, 2016Q1]
+//
+// One file can serve all the commands: each command uses the entries that are its flags.  A flag is set by, in
+// order of precedence: the command line, the environment, the config file, its default.  The password comes from
+// -password-file if that is set by a higher-precedence source than -password.  If both are set by the same source,
+// -password wins.
+//
+// load flags:
+//
 //	-table ClickHouse table in which to insert the data.
 //	-create if Y, then the table is created/reset. Default: Y.
-//	-dir directory with Freddie Mac text files.
-//	-tmp ClickHouse database to use for temporary tables.
+//	-dir directory with Freddie Mac text files or the zip archives (historical_data_CCYYQn.zip) that hold them.
+//	     The text files may be compressed with gzip (.gz) or zstd (.zst).  Subdirectories are searched too.
+//	-tmp ClickHouse database to use for temporary tables.  Each quarter uses its own temporary tables.
 //	-concur # of concurrent processes to use in loading monthly files. Default: 1.
-//	-memory max memory usage by ClickHouse.  Default: 40000000000.
-//	-groupby max_bytes_before_external_groupby ClickHouse parameter. Default: 20000000000.
+//	-quarters-parallel # of quarters to load at once. -memory and -groupby are divided among them. Default: 1.
+//	-manifest ClickHouse table that tracks the status of each quarter. Default: <table>_manifest.
+//	-resume if Y, quarters the manifest shows as done are skipped. Default: N.
+//	-from first quarter to load, e.g. 2010Q1. Default: <first quarter in -dir>.
+//	-to last quarter to load, e.g. 2012Q4. Default: <last quarter in -dir>.
+//	-quarters comma-separated list of quarters to load, e.g. 2015Q3,2016Q1. Default: <all quarters in -dir>.
+//	-replace if Y, loans already in -table for a quarter are deleted before the quarter is loaded. Default: N.
+//	-swap if Y, the quarters are loaded into <table>_staging which replaces -table once all quarters are loaded.
+//	      Default: N.
+//	-runs ClickHouse table that records each run. Default: <table>_runs.
+//	-loss-threshold the fraction of rows a quarter may lose at a stage of the load before it fails. Default: 0.001.
+//	-fingerprint if Y, the SHA-256, size and line count of each file are recorded.  This reads each file a second
+//	             time. Default: Y.
+//	-dry-run if Y, the files are read and validated but nothing is loaded.  ClickHouse is not needed. Default: N.
+//	-metrics-addr address on which to serve Prometheus metrics at /metrics while loading, e.g. :9100.
+//	              Default: <none>.
+//	-as-of the latest legal date when the files are validated, as CCYY-MM-DD.  Default: <inferred from the data>.
+//	-spec YAML or JSON file with the validation spec (see below). Default: <the built-in spec>.
+//	-cbsa directory of CBSA delineation files that give the legal msaD codes (see below). Default: <none>.
+//	-cbsa-vintage the vintage (year) of the delineation in -cbsa to use. Default: <the latest by the as-of date>.
+//	-zip3 CSV crosswalk from 3 digit zips to states, to check state against zip (see below). Default: <none>.
+//
+// verify flags: -table, -dir, -from, -to, -quarters as for load.  For each quarter, the lines in the static and
+// monthly files are counted and compared to the loans and loan-months in -table.  A few loans in the static file
+// may be missing from -table (see below).  verify fails if a quarter has no loans in -table or more than its files.
+//
+// describe flags: -table.
+//
+// drop-quarter flags: -table, -manifest as for load, and
+//
+//	-quarter the quarter to drop, e.g. 2010Q1, or the year for the sample dataset.
+//	-standard Y to drop the standard loans of the quarter, N for the non-standard loans. Default: Y.
+//
+// status flags: -table, -manifest as for load.
+//
+// reconcile flags: -table, and -dir, -from, -to, -quarters as for load, and
+//
+//	-published CSV of Freddie's published figures: a header row with the columns quarter (CCYYQn), loans and,
+//	           optionally, standard (Y/N, default Y) and upb.
+//
+// reconcile lists, for each quarter and standard in -published, the published loans and UPB, those in -table and
+// the differences.  If -dir is given, the lnIds of each quarter in -dir that are not in -table are listed as CSV
+// with the stage at which they fall out: static-only (not in the monthly file), monthly-only (not in the static
+// file) and failed-parsing (in both files but not loaded).
+//
+// spec flags: none beyond the connection flags, which it doesn't use.
+//
+// With -dry-run Y, each quarter's files are run through the static and monthly TableDefs, their validation and
+// the calculated fields, in-process.  The pass, default (empty field) and fail counts of each field are printed for
+// each quarter, followed by the row counts.  This checks that a new release parses before loading it.
+//
+// The plan -- the quarters to load and their source files -- is printed before the load starts.
+//
+// With -replace Y, rerunning a quarter with -create N does not duplicate its loans.  The loans are identified
+// by the quarter in lnId and the standard flag. Quarters retried with -resume Y are always replaced.
+//
+// With -swap Y, -table is not touched until every quarter has loaded, so queries against it never see a partial
+// table.  With -create N, the staging table starts as a copy of -table.  After the last quarter, the staging table
+// and -table are exchanged (EXCHANGE TABLES needs a database with the Atomic engine) and the old data is dropped.
+// If the load fails, -table is left as it was.  Rerun with -resume Y and -swap Y to finish the staging table.
+//
+// The manifest table has one row per quarter loaded into -table.  It records the source files, row counts, status
+// (started, done, failed) and timestamps.  If a run dies part way through, rerun it with -resume Y to load just
+// the quarters that failed or are missing.  If -create Y and -resume N, the manifest entries for -table are reset.
+//
+// The manifest also records the SHA-256, size and line count of each source file -- of the content, after
+// decompression -- and the rows at each stage of the load: the temp static and monthly tables and the loans joined.
+// If a quarter loses more than -loss-threshold of its rows at any stage, it fails.  Smaller losses are logged as
+// warnings.  Some loss from the static table to the loans is expected (see below).  Fingerprinting reads each file
+// a second time while it loads.  For large monthly files, -fingerprint N skips it, at the cost of the checksums
+// and the checks of the losses from the files to the temp tables.
+//
+// Each run of load writes a row to the runs table: the run id, the target table, the flags (except the password),
+// the as-of date, the version of the validation spec, the vintage of the CBSA delineation, the version of the
+// binary and of ClickHouse, the minutes taken by each quarter, the status (started, done, failed) with any error,
+// and the start and end times.  The run id is stored on each loan the run loads (runId).
+//
+// Progress goes to stderr as a log of events, each a message with key/value pairs.  With -log-format json, each
+// event is a JSON object with time, level, msg and the keys, for a log shipper or scheduler.  The events of load are
+// the run starting, the plan, each stage of each quarter (static parse, monthly parse, join, insert, cleanup) with
+// its rows and seconds, each quarter done, warnings and the run done.  The parse stages also report the bytes of
+// the source files done out of the total and an estimate of the seconds left (etaSeconds).  Reports and tables, such
+// as the dry-run profiles, still go to stdout.
+//
+// The date fields are validated against an as-of date rather than today: fpDt, month, zbDt, lpDt and the like can't
+// be after it, and matDt can't be more than 40 years after it.  By default, it is the release date inferred from
+// the data: the end of the last month in the monthly file of the latest quarter in -dir.  So the same files give
+// the same QA results whenever they are loaded.  The as-of date is recorded in the runs table.
+//
+// The validation spec -- the legal range or levels, missing value and default of each field -- is built in.  With
+// -spec, a YAML or JSON file overrides it for the fields the file lists, e.g.
+//
+//	version: risk-2024-03
+//	static:
+//	  fico: {min: 300, max: 850}
+//	monthly:
+//	  upb: {max: 3000000}
+//
+// A spec file must have a version.  The version of the spec used, or builtin-1, is recorded in the runs table, so
+// the QA results of a load can be traced to the rules that produced them.  freddie spec prints the built-in spec in
+// this form, as a start for a spec file.
+//
+// msaD is checked against a list of MSA/division codes built into the static package.  OMB revises the
+// delineations every few years, so newer codes fail that list.  With -cbsa, the legal codes come from a CBSA
+// delineation file instead: the Census Bureau's list 1 saved as CSV, with the vintage (year) in its name, e.g.
+// list1_2023.csv (see the cbsa package).  The vintage is chosen by -cbsa-vintage or else is the latest in -cbsa not
+// after the as-of date.  The delineation also fills in msaName, the name of the msa or division, and cbsa and
+// cbsaName, the code and name of its metropolitan area.  Without -cbsa, they are empty.  The vintage used, or
+// builtin, is recorded in the runs table.  With both -cbsa and -spec, the legal codes are the delineation's,
+// whatever msaD levels the spec lists.
+//
+// Besides the checks of each field, the static data is checked by cross-field rules.  A loan that fails a rule has
+// the rule's name in qa.field, as a field that fails validation does:
+//   - matDtTerm.  matDt is not fpDt + term - 1 months.
+//   - cltvLtv.  cltv is less than ltv.
+//   - harpPreHarpLnId.  harp is Y but preHarpLnId is empty.
+//   - miLtv.  mi is more than 0 with an ltv below 80.  This is rare rather than wrong.
+//   - zipState.  state is not one of the states of the zip3 of zip, with -zip3.
+//
+// A rule is checked only if the fields it tests pass validation, so a bad field isn't counted twice.
+//
+// Each entry of the qa nested table has a reason code, so that QA issues can be triaged without going back to the
+// source files:
+//   - empty.  The field is empty and has no default.
+//   - parse.  The field can't be parsed as its type, e.g. a letter in a number or a bad date.
+//   - long.  The field is longer than its fixed-length string.
+//   - low.  The value is below the minimum.
+//   - high.  The value is above the maximum.
+//   - range.  The value of a calculated field (e.g. propVal) is out of range.
+//   - level.  The value is not one of the legal levels.
+//   - rule.  The loan fails the cross-field rule named in field.
+//
+// A monthly field can fail for different reasons in different months.  It then has an entry for each reason,
+// with the months that failed for that reason.
+//
+// zip and state are each validated on their own.  With -zip3, state is also checked against a crosswalk from the
+// zip3s to the states and territories they cover (see the zip3 package).  The crosswalk is a CSV file with a
+// header of zip3 (or zip, for a list of 5 digit zips), state and, optionally, weight -- the share of the zip3 in
+// the state, e.g. its # of addresses.  A loan whose state is not one of the states of its zip3 fails zipState.
+// Zip3s not in the crosswalk aren't checked.  zip3State is the state of the zip3 with the most weight, or with
+// the most zips if there is no weight column.  Without -zip3, it is empty.
+//
+// With -metrics-addr, the load serves Prometheus metrics (see the metrics package): the rows read per second by
+// each static and monthly file load, the rows written, the validation failures per field, the quarters being
+// loaded and the time spent in the join query.
+//
+// SIGINT or SIGTERM stops the run cleanly: the quarters in progress stop, their temporary tables are dropped and
+// the manifest marks them failed, so -resume Y picks them up.  A second signal exits at once.
 //
 // Since the standard and non-standard datasets have the same format, this utility can be used to create tables
 // using either source.  A combined table can be built by running the app twice pointing to the same -table.
 // On the first run, set -create Y and set -create N for the second run.
 //
+// The files in -dir are recognized by name:
+//
+//	historical_data_CCYYQn.txt, historical_data_time_CCYYQn.txt            standard dataset
+//	historical_data_excl_CCYYQn.txt, historical_data_excl_time_CCYYQn.txt  non-standard dataset
+//	historical_data1_QnCCYY.txt, historical_data1_time_QnCCYY.txt          older releases
+//	sample_orig_CCYY.txt, sample_svcg_CCYY.txt                             sample dataset
+//
+// each optionally followed by .gz or .zst.  Other files are ignored, as are zip archives that can't be read.  The
+// files that are ignored, with why, and those without their static or monthly mate are listed before the load
+// starts.  A selected quarter must have both files.  It is
+// an error for -dir to hold the standard and non-standard files of the same quarter.
+//
+// Freddie's sample dataset (sample_orig_CCYY.txt, sample_svcg_CCYY.txt, or the sample_CCYY.zip archives) is
+// recognized too.  Its files cover a year, so they are keyed by year: -from, -to, -quarters and drop-quarter's
+// -quarter take CCYY for them.  A year is within -from/-to if any of its quarters are.  The sample field is Y for
+// these loans.
+//
+// The load command is a thin wrapper over the pipeline package, which Go programs can use to run the load
+// themselves.
+//
 // Look at the example in the joined package for the DESCRIBE output of the table.
 //
 // Note that the table produced by this package has slightly fewer loans than the check figures provided by Freddie.
 // The difference seems to be that there are some loans in the static file that are not in the monthly file.
 // With data through 2021Q3, this totals 1484 standard loans (HARP and non-HARP), and 207 non-standard loans.
+// The reconcile command lists these loans.
 package main
 
 import (
@@ -49,99 +258,116 @@ import (
 	"fmt"
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/invertedv/chutils"
-	"github.com/invertedv/freddie/joined"
-	"log"
+	"github.com/invertedv/freddie/logger"
 	"os"
-	"sort"
+	"runtime/debug"
 	"strings"
-	"time"
 )
 
-func main() {
-	var err error
-	host := flag.String("host", "127.0.0.1", "string")
-	user := flag.String("user", "default", "string")
-	password := flag.String("password", "", "string")
-	srcDir := flag.String("dir", "", "string")
-	create := flag.String("create", "Y", "string")
-	table := flag.String("table", "", "string")
-	tmp := flag.String("tmp", "", "string")
-	nConcur := flag.Int("concur", 1, "int")
-	max_memory := flag.Int64("memory", 40000000000, "int64")
-	max_groupby := flag.Int64("groupby", 20000000000, "int64")
+// commands maps each command to the function that runs it.  The function is passed the command-line arguments
+// after the command.
+var commands = map[string]func(args []string) error{
+	"load":         runLoad,
+	"verify":       runVerify,
+	"describe":     runDescribe,
+	"drop-quarter": runDropQuarter,
+	"status":       runStatus,
+	"reconcile":    runReconcile,
+	"spec":         runSpec,
+}
 
-	// Each quarter of data consists of two files: one for static data, one for monthly data
-	type filePair struct {
-		Static  string
-		Monthly string
+func main() {
+	// with no command, the command is load
+	cmd, args := "load", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
-
-	flag.Parse()
-
-	// add trailing slash, if needed
-	if (*srcDir)[len(*srcDir)-1] != '/' {
-		*srcDir += "/"
+	run, ok := commands[cmd]
+	if !ok {
+		lg.Error("failed", "command", cmd, "error", "unknown command")
+		usage()
+		os.Exit(2)
 	}
-	// connect to ClickHouse
-	con, err := chutils.NewConnect(*host, *user, *password, clickhouse.Settings{
-		"max_memory_usage":                   *max_memory,
-		"max_bytes_before_external_group_by": *max_groupby,
-	})
-	if err != nil {
-		log.Fatalln(err)
+	if e := run(args); e != nil {
+		lg.Error("failed", "command", cmd, "error", e)
+		os.Exit(1)
 	}
-	defer func() {
-		if e := con.Close(); e != nil {
-			log.Fatalln(e)
-		}
-	}()
+}
 
-	// holds the set of files to work through
-	fileList := make(map[string]*filePair)
+// usage prints the commands to stderr
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: freddie <command> <flags>
 
-	dir, err := os.ReadDir(*srcDir)
-	if err != nil {
-		log.Fatalln(fmt.Errorf("error reading directory: %s", *srcDir))
-	}
+The commands are:
 
-	// build the file list
-	for _, f := range dir {
-		if ind := strings.Index(f.Name(), ".txt"); ind > 0 {
-			root := f.Name()[ind-6 : ind] // Year & Quarter CCYY"Q"Q
-			if fileList[root] == nil {
-				fileList[root] = new(filePair)
-			}
-			if strings.Index(f.Name(), "time") > 0 {
-				fileList[root].Monthly = *srcDir + f.Name()
-			} else {
-				fileList[root].Static = *srcDir + f.Name()
-			}
-		}
-	}
+	load           load the quarters in -dir into -table (the default, if the first argument is a flag)
+	verify         compare the loans of each quarter in -table against the source files in -dir
+	describe       print the fields of -table with their descriptions
+	drop-quarter   delete the loans of a quarter from -table
+	status         show the quarters loaded into -table, from the manifest
+	reconcile      compare the loans in -table with Freddie's published figures
+	spec           print the built-in validation spec as YAML
+`)
+}
 
-	// Check we got pairs
-	for k, v := range fileList {
-		if v.Monthly == "" || v.Static == "" {
-			log.Fatalln(fmt.Errorf("quarter %s is missing static or monthly", k))
-		}
+// lg is the logger for progress and errors.  It is set up by parse.
+var lg, _ = logger.New(os.Stderr, logger.Text)
+
+// connOpts are the ClickHouse connection flags shared by the commands, along with the config file and log format
+type connOpts struct {
+	host         *string
+	user         *string
+	pass         *string
+	passwordFile *string
+	memory       *int64
+	groupby      *int64
+	config       *string
+	logFormat    *string
+}
+
+// connFlags adds the connection flags, -config and -log-format to fs
+func connFlags(fs *flag.FlagSet) *connOpts {
+	return &connOpts{
+		host:         fs.String("host", "127.0.0.1", "string"),
+		user:         fs.String("user", "default", "string"),
+		pass:         fs.String("password", "", "string"),
+		passwordFile: fs.String("password-file", "", "string"),
+		memory:       fs.Int64("memory", 40000000000, "int64"),
+		groupby:      fs.Int64("groupby", 20000000000, "int64"),
+		config:       fs.String("config", "", "string"),
+		logFormat:    fs.String("log-format", logger.Text, "string"),
 	}
+}
+
+// connect connects to ClickHouse.  The memory limits are divided among nShare queries that run at once.
+func (c *connOpts) connect(nShare int) (*chutils.Connect, error) {
+	return chutils.NewConnect(*c.host, *c.user, *c.pass, clickhouse.Settings{
+		"max_memory_usage":                   *c.memory / int64(nShare),
+		"max_bytes_before_external_group_by": *c.groupby / int64(nShare),
+	})
+}
 
-	// create a slice of keys.  We'll work through the data in chronological order
-	keys := make([]string, 0, len(fileList))
-	for k := range fileList {
-		keys = append(keys, k)
+// yes returns true if flag value v is Y or y
+func yes(v string) bool {
+	return v == "Y" || v == "y"
+}
+
+// version returns the module version of the binary and, if it was built from a git checkout, the commit
+func version() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
 	}
-	sort.Strings(keys)
-	start := time.Now()
-	createTable := *create == "Y" || *create == "y"
-	for ind, k := range keys {
-		s := time.Now()
-		if e := joined.Load(fileList[k].Monthly, fileList[k].Static, *table, *tmp, createTable, *nConcur, con); e != nil {
-			log.Fatalln(e)
+	v := bi.Main.Version
+	for _, st := range bi.Settings {
+		switch st.Key {
+		case "vcs.revision":
+			v += " " + st.Value
+		case "vcs.modified":
+			if st.Value == "true" {
+				v += " (modified)"
+			}
 		}
-		createTable = false
-
-		fmt.Printf("Done with quarter %s. %d out of %d: time %0.2f minutes\n", k, ind+1, len(keys), time.Since(s).Minutes())
 	}
-	fmt.Printf("elapsed time: %0.2f hours\n", time.Since(start).Hours())
+	return v
 }
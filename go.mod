@@ -2,11 +2,15 @@ module github.com/invertedv/freddie
 
 go 1.18
 
-require github.com/invertedv/chutils v1.1.10
+require (
+	github.com/ClickHouse/clickhouse-go/v2 v2.0.14
+	github.com/google/uuid v1.3.0
+	github.com/invertedv/chutils v1.1.10
+	github.com/klauspost/compress v1.15.15
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/ClickHouse/clickhouse-go/v2 v2.0.14 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/paulmach/orb v0.7.1 // indirect
 	github.com/pierrec/lz4/v4 v4.1.14 // indirect
 	github.com/shopspring/decimal v1.3.1 // indirect
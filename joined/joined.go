@@ -2,37 +2,107 @@
 package joined
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/invertedv/chutils"
 	s "github.com/invertedv/chutils/sql"
 	mon "github.com/invertedv/freddie/monthly"
+	"github.com/invertedv/freddie/spec"
 	stat "github.com/invertedv/freddie/static"
 	"strings"
+	"time"
 )
 
-// func Load loads the monthly and static files into tmpDB.monthly & tmpDB.static, then joins them and inserts
+// Counts holds the row counts for a single quarter
+type Counts struct {
+	Static  int64 // Static is the # of rows loaded into the static temp table
+	Monthly int64 // Monthly is the # of rows loaded into the monthly temp table
+	Loans   int64 // Loans is the # of loans inserted into the output table
+}
+
+// the stages of Load
+const (
+	StageStatic  = "static parse"  // StageStatic loads the static file into its temp table
+	StageMonthly = "monthly parse" // StageMonthly loads the monthly file into its temp table
+	StageJoin    = "join"          // StageJoin builds the TableDef of the join query and creates the table
+	StageInsert  = "insert"        // StageInsert runs the join query and inserts its rows into the table
+	StageCleanup = "cleanup"       // StageCleanup drops the temp tables
+)
+
+// StageFn is called by Load as each stage finishes.  rows is the # of rows the stage produced (0 for join and
+// cleanup) and elapsed is the time it took.
+type StageFn func(stage string, rows int64, elapsed time.Duration)
+
+// func Load loads the monthly and static files into temp tables in tmpDB, then joins them and inserts
 // the output into "table".  If create="Y", table is created/reset.  The monthly file is read/loaded using
-// nConcur processes.
-func Load(monthly string, static string, table string, tmpDB string, create bool, nConcur int, con *chutils.Connect) error {
+// nConcur processes.  runID, the id of the run doing the load, is stored on each loan.  set holds the
+// settings the files are validated with (see static.LoadRaw, monthly.LoadRaw).  The row counts at
+// each step are returned.
+//
+// The temp tables are tmpDB.static_<id> and tmpDB.monthly_<id>, where id is unique to the call, so several
+// quarters can be loaded at once.  The temp tables are dropped whether the load succeeds or not.
+//
+// If ctx is cancelled, the load stops and ctx.Err() is returned.  Rows already inserted into table are not removed.
+//
+// If stage is not nil, it is called as each stage of the load finishes (see StageFn).
+func Load(ctx context.Context, runID string, monthly string, static string, table string, tmpDB string, create bool,
+	nConcur int, set *spec.Settings, stage StageFn, con *chutils.Connect) (cnts *Counts, err error) {
+	if stage == nil {
+		stage = func(string, int64, time.Duration) {}
+	}
+	id, err := tmpID()
+	if err != nil {
+		return nil, err
+	}
+	tmpStatic := fmt.Sprintf("%s.static_%s", tmpDB, id)
+	tmpMonthly := fmt.Sprintf("%s.monthly_%s", tmpDB, id)
+	defer func() {
+		// clean up.  Use a fresh context since ctx may be cancelled.
+		start := time.Now()
+		for _, t := range []string{tmpStatic, tmpMonthly} {
+			if _, e := con.ExecContext(context.Background(), "DROP TABLE IF EXISTS "+t); e != nil && err == nil {
+				cnts, err = nil, e
+			}
+		}
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		stage(StageCleanup, 0, time.Since(start))
+	}()
+
+	cnts = &Counts{}
+	var e error
 	// load static data into temp table
-	tmpStatic := tmpDB + ".static"
-	if e := stat.LoadRaw(static, tmpStatic, true, con); e != nil {
-		return e
+	start := time.Now()
+	if e := stat.LoadRaw(ctx, static, tmpStatic, true, set, con); e != nil {
+		return nil, e
 	}
+	if cnts.Static, e = count(tmpStatic, "", con); e != nil {
+		return nil, e
+	}
+	stage(StageStatic, cnts.Static, time.Since(start))
+
 	// load monthly data into temp table
-	tmpMonthly := tmpDB + ".monthly"
-	if e := mon.LoadRaw(monthly, tmpMonthly, true, nConcur, con); e != nil {
-		return e
+	start = time.Now()
+	if e := mon.LoadRaw(ctx, monthly, tmpMonthly, true, nConcur, set, con); e != nil {
+		return nil, e
 	}
+	if cnts.Monthly, e = count(tmpMonthly, "", con); e != nil {
+		return nil, e
+	}
+	stage(StageMonthly, cnts.Monthly, time.Since(start))
+	start = time.Now()
 
 	// fill in placeholders in the JOIN query
-	qryUse := strings.Replace(strings.Replace(qry, "tmpMonthly", tmpMonthly, -1), "tmpStatic", tmpStatic, -1)
+	qryUse := strings.NewReplacer("tmpMonthly", tmpMonthly, "tmpStatic", tmpStatic, "tmpRunId", runID).Replace(qry)
 
 	// build sql reader
 	srdr := s.NewReader(qryUse, con)
 	// initialize the TableDef
 	if e := srdr.Init("lnId", chutils.MergeTree); e != nil {
-		return e
+		return nil, e
 	}
 	// fill in the descriptions of the fields
 	for _, fd := range srdr.TableSpec().FieldDefs {
@@ -56,13 +126,21 @@ func Load(monthly string, static string, table string, tmpDB string, create bool
 			fd.Description = "month of foreclosure resolution"
 		case "ageFpDt":
 			fd.Description = "age based on fdDt, missing=-1000"
+		case "runId":
+			fd.ChSpec.Funcs = append(fd.ChSpec.Funcs, chutils.OuterLowCardinality)
+			fd.Description = "id of the run that loaded the loan, see the runs table"
 		case "standard":
 			fd.Description = "standard u/w process loan: Y, N"
+		case "sample":
+			fd.Description = "loan from the sample dataset: Y, N"
 		case "field":
 			fd.ChSpec.Funcs = append(fd.ChSpec.Funcs, chutils.OuterLowCardinality)
-			fd.Description = "failed qa: field name array"
+			fd.Description = "failed qa: field or rule name array"
+		case "reason":
+			fd.ChSpec.Funcs = append(fd.ChSpec.Funcs, chutils.OuterLowCardinality)
+			fd.Description = "failed qa: reason code array (see qa package)"
 		case "cntFail":
-			fd.Description = "# months that failed qa"
+			fd.Description = "# months that failed qa for the reason"
 		case "allFail":
 			fd.ChSpec.Funcs = append(fd.ChSpec.Funcs, chutils.OuterLowCardinality)
 			fd.Description = "fields that failed qa all months"
@@ -70,35 +148,212 @@ func Load(monthly string, static string, table string, tmpDB string, create bool
 	}
 	// Nested arrays for the monthly data
 	if e := srdr.TableSpec().Nest("monthly", "month", "bap"); e != nil {
-		return e
+		return nil, e
 	}
 	// Nested arrays for modifications data
 	if e := srdr.TableSpec().Nest("mod", "modMonth", "stepMod"); e != nil {
-		return e
+		return nil, e
 	}
 	if e := srdr.TableSpec().Nest("qa", "field", "cntFail"); e != nil {
-		return e
+		return nil, e
 	}
 
 	srdr.Name = table
 	if create {
 		if e := srdr.TableSpec().Create(con, srdr.Name); e != nil {
-			return e
+			return nil, e
 		}
 	}
-	// Insert the data into the table
-	if e := srdr.Insert(); e != nil {
+	stage(StageJoin, 0, time.Since(start))
+
+	// Insert the data into the table.  This is srdr.Insert() with the context.
+	start = time.Now()
+	if _, e := con.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s %s", srdr.Name, srdr.Sql)); e != nil {
+		return nil, e
+	}
+	// just the loans of this run: a rerun of the file may have left loans from an earlier run
+	if cnts.Loans, e = count(table, "fileStatic = $1 AND runId = $2", con, static, runID); e != nil {
+		return nil, e
+	}
+	stage(StageInsert, cnts.Loans, time.Since(start))
+	return cnts, nil
+}
+
+// Standard returns the value of the standard field for the loans in fileStatic: "N" if the file is a
+// non-standard (excl) file, "Y" otherwise.
+func Standard(fileStatic string) string {
+	if strings.Contains(fileStatic, "excl") {
+		return "N"
+	}
+	return "Y"
+}
+
+// quarterWhere returns the WHERE clause that selects the loans of a quarter or, for the sample dataset, a year.
+// The loans are found by the quarter or year embedded in lnId (e.g. F10Q1 for quarter 2010Q1), the standard field
+// (see Standard) and the sample field.
+func quarterWhere(quarter string, standard string) (string, error) {
+	if standard != "Y" && standard != "N" {
+		return "", fmt.Errorf("bad standard %s, need Y or N", standard)
+	}
+	switch len(quarter) {
+	case 4:
+		return fmt.Sprintf("substr(lnId, 2, 2) = '%s' AND standard = '%s' AND sample = 'Y'", quarter[2:4], standard), nil
+	case 6:
+		return fmt.Sprintf("substr(lnId, 2, 4) = '%s' AND standard = '%s' AND sample = 'N'", quarter[2:6], standard), nil
+	}
+	return "", fmt.Errorf("bad quarter %s", quarter)
+}
+
+// Delete deletes the loans of a quarter from table.  standard is "Y" for standard loans and "N" for non-standard
+// loans.  For the sample dataset, quarter is the year (e.g. 2010).  The delete is done before returning.  If table
+// does not exist, there is nothing to do.
+func Delete(table string, quarter string, standard string, con *chutils.Connect) error {
+	if ok, e := Exists(table, con); e != nil || !ok {
 		return e
 	}
+	where, err := quarterWhere(quarter, standard)
+	if err != nil {
+		return err
+	}
+	_, err = con.Exec(fmt.Sprintf("ALTER TABLE %s DELETE WHERE %s SETTINGS mutations_sync = 2", table, where))
+	return err
+}
+
+// QuarterCounts returns the # of loans and the # of loan-months of a quarter in table.  standard is as in Delete.
+func QuarterCounts(table string, quarter string, standard string, con *chutils.Connect) (loans int64, months int64, err error) {
+	where, err := quarterWhere(quarter, standard)
+	if err != nil {
+		return 0, 0, err
+	}
+	qry := fmt.Sprintf("SELECT toInt64(count(*)), toInt64(sum(length(monthly.month))) FROM %s WHERE %s", table, where)
+	err = con.QueryRow(qry).Scan(&loans, &months)
+	return
+}
 
-	// clean up
-	if _, e := con.Exec(fmt.Sprintf("DROP TABLE %s", tmpStatic)); e != nil {
+// Totals are the # of loans and their origination balance for a quarter
+type Totals struct {
+	Loans int64   // Loans is the # of loans
+	UPB   float64 // UPB is the total balance at origination (opb)
+}
+
+// QuarterTotals returns the Totals of the loans in table, keyed by the origination quarter in lnId (e.g. 2010Q1) and
+// then the standard field.  The sample dataset is not included.
+func QuarterTotals(table string, con *chutils.Connect) (map[string]map[string]*Totals, error) {
+	qry := fmt.Sprintf(`
+SELECT
+    concat(toUInt8(substr(lnId, 2, 2)) > 90 ? '19' : '20', substr(lnId, 2, 4)) AS quarter,
+    toString(standard) AS std,
+    toInt64(count(*)),
+    sum(toFloat64(opb))
+FROM %s
+WHERE sample = 'N'
+GROUP BY quarter, std`, table)
+	rows, err := con.Query(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	totals := make(map[string]map[string]*Totals)
+	for rows.Next() {
+		var quarter, standard string
+		t := &Totals{}
+		if e := rows.Scan(&quarter, &standard, &t.Loans, &t.UPB); e != nil {
+			return nil, e
+		}
+		if totals[quarter] == nil {
+			totals[quarter] = make(map[string]*Totals)
+		}
+		totals[quarter][standard] = t
+	}
+	return totals, rows.Err()
+}
+
+// LoanIDs returns the lnIds of a quarter in table.  quarter and standard are as in Delete.
+func LoanIDs(table string, quarter string, standard string, con *chutils.Connect) (map[string]bool, error) {
+	where, err := quarterWhere(quarter, standard)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := con.Query(fmt.Sprintf("SELECT lnId FROM %s WHERE %s", table, where))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if e := rows.Scan(&id); e != nil {
+			return nil, e
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// Copy creates table "to" with the structure of table "from" and copies the data in "from" into it.  If "to"
+// exists, it is replaced.
+func Copy(from string, to string, con *chutils.Connect) error {
+	qrys := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", to),
+		fmt.Sprintf("CREATE TABLE %s AS %s", to, from),
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", to, from),
+	}
+	for _, qry := range qrys {
+		if _, e := con.Exec(qry); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Swap replaces table with staging.  If table exists, the two are exchanged in a single step and the old data,
+// now in staging, is dropped.  Otherwise, staging is renamed to table.  EXCHANGE TABLES requires the database to
+// use the Atomic engine.
+func Swap(staging string, table string, con *chutils.Connect) error {
+	ok, err := Exists(table, con)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		_, e := con.Exec(fmt.Sprintf("RENAME TABLE %s TO %s", staging, table))
 		return e
 	}
-	if _, e := con.Exec(fmt.Sprintf("DROP TABLE %s", tmpMonthly)); e != nil {
+	if _, e := con.Exec(fmt.Sprintf("EXCHANGE TABLES %s AND %s", staging, table)); e != nil {
 		return e
 	}
-	return nil
+	_, err = con.Exec(fmt.Sprintf("DROP TABLE %s", staging))
+	return err
+}
+
+// Exists returns true if table exists
+func Exists(table string, con *chutils.Connect) (bool, error) {
+	var exists uint8
+	if e := con.QueryRow(fmt.Sprintf("EXISTS TABLE %s", table)).Scan(&exists); e != nil {
+		return false, e
+	}
+	return exists == 1, nil
+}
+
+// tmpID returns a random id for the temp table names
+func tmpID() (string, error) {
+	b := make([]byte, 6)
+	if _, e := rand.Read(b); e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// count returns the number of rows in table that satisfy where.  If where is empty, all rows are counted.  args
+// are the values of the parameters ($1, $2, ...) in where.
+func count(table string, where string, con *chutils.Connect, args ...interface{}) (n int64, err error) {
+	qry := fmt.Sprintf("SELECT toInt64(count(*)) FROM %s", table)
+	if where != "" {
+		qry = fmt.Sprintf("%s WHERE %s", qry, where)
+	}
+	err = con.QueryRow(qry, args...).Scan(&n)
+	return
 }
 
 // qry is the query that does the join
@@ -106,26 +361,46 @@ const qry = `
 WITH qMonthly AS (
     SELECT 
         lnId, 
-        groupArray(grp) AS qa,
+        groupArray(fld) AS qa,
+        groupArray(rsn) AS rqa,
         groupArray(n) AS nqa
     FROM (
         SELECT 
             lnId, 
             arrayJoin(splitByChar(':', qaMonthly)) AS grp,
+            splitByChar('=', grp)[1] AS fld,
+            splitByChar('=', grp)[2] AS rsn,
             toInt32(count(*)) AS n
         FROM tmpMonthly 
         WHERE grp != ''
         GROUP BY lnId, grp)
     GROUP BY lnId),
+qMonthlyAll AS (
+    SELECT 
+        lnId, 
+        groupArray(fld) AS qa,
+        groupArray(n) AS nqa
+    FROM (
+        SELECT 
+            lnId, 
+            splitByChar('=', arrayJoin(splitByChar(':', qaMonthly)))[1] AS fld,
+            toInt32(count(*)) AS n
+        FROM tmpMonthly 
+        WHERE fld != ''
+        GROUP BY lnId, fld)
+    GROUP BY lnId),
 qStatic AS (
     SELECT 
         lnId, 
-        groupArray(grp) AS qa,
+        groupArray(fld) AS qa,
+        groupArray(rsn) AS rqa,
         groupArray(n) AS nqa
     FROM (
         SELECT 
             lnId, 
             arrayJoin(splitByChar(':', qaStatic)) AS grp,
+            splitByChar('=', grp)[1] AS fld,
+            splitByChar('=', grp)[2] AS rsn,
             toInt32(count(*)) AS n
         FROM tmpStatic 
         WHERE grp != ''
@@ -137,6 +412,9 @@ SELECT
     firstTime,
     matDt,
     msaD,
+    msaName,
+    cbsa,
+    cbsaName,
     mi,
     units,
     occ,
@@ -151,6 +429,7 @@ SELECT
     state,
     propType,
     substr(zip, 1, 3) AS zip3,
+    zip3State,
     s.lnId,
     toInt32(modulo(arraySum(bitPositionsToArray(reinterpretAsUInt64(substr(s.lnId, 5, 8)))), 20)) AS bucket,
     purpose,
@@ -168,6 +447,7 @@ SELECT
     vintage,
     propVal,
     position(fileStatic, 'excl') = 0 ? 'Y' : 'N' AS standard,
+    position(fileStatic, 'sample_orig_') > 0 ? 'Y' : 'N' AS sample,
     m.month,
     m.upb,
 //    m.dqStat,
@@ -192,6 +472,7 @@ SELECT
     m.zbDt,
     m.zbUpb,
     m.fileMonthly,
+    'tmpRunId' AS runId,
 
     arrayElement(m.fclMonth, length(m.fclMonth)) AS fclMonth,
     arrayElement(m.fclProNet1, length(m.fclMonth)) AS fclProNet,
@@ -209,9 +490,10 @@ SELECT
     m.modCLoss1 AS modCLoss,
     m.stepMod1 AS stepMod,
     arrayConcat(qStatic.qa, qMonthly.qa) AS field,
+    arrayConcat(qStatic.rqa, qMonthly.rqa) AS reason,
     arrayConcat(qStatic.nqa, qMonthly.nqa) AS cntFail,
     arrayConcat(qStatic.qa,
-         arrayFilter((x,y) -> y=length(month) ? 1 : 0, qMonthly.qa, qMonthly.nqa)) AS allFail
+         arrayFilter((x,y) -> y=length(month) ? 1 : 0, qMonthlyAll.qa, qMonthlyAll.nqa)) AS allFail
 FROM
     tmpStatic AS s
 JOIN (
@@ -263,6 +545,8 @@ JOIN (
 ON s.lnId = m.lnId
 LEFT JOIN qMonthly 
 ON s.lnId = qMonthly.lnId
+LEFT JOIN qMonthlyAll
+ON s.lnId = qMonthlyAll.lnId
 LEFT JOIN qStatic
 ON s.lnId = qStatic.lnId
 `
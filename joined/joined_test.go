@@ -38,6 +38,9 @@ func ExampleLoad() {
 	//firstTime            FixedString(1)                  first time homebuyer: Y, N, missing=X
 	//matDt                Date                            loan maturity date (initial), missing=1970/1/1
 	//msaD                 FixedString(5)                  msa/division code, missing/not in MSA=XXXXX
+	//msaName              LowCardinality(String)          name of msa/division (from -cbsa), missing=<empty>
+	//cbsa                 LowCardinality(String)          cbsa code of msa, the parent of a division (from -cbsa), missing=<empty>
+	//cbsaName             LowCardinality(String)          name of cbsa (from -cbsa), missing=<empty>
 	//mi                   Int32                           mi percentage, 0-55, missing=-1
 	//units                Int32                           # of units in the property, 1-4, missing=-1
 	//occ                  FixedString(1)                  property occupancy: P (primary), S (secondary), I (investor), missing=X
@@ -52,6 +55,7 @@ func ExampleLoad() {
 	//state                FixedString(2)                  property state postal abbreviation, missing=XX
 	//propType             FixedString(2)                  property type: SF (single family), CO (condo), PU (PUD), CP (coop), MH (manufactured), missing=XX
 	//zip3                 FixedString(3)                  3 digit zip
+	//zip3State            LowCardinality(String)          majority state of the 3 digit zip (from -zip3), missing=<empty>
 	//lnId                 String                          loan ID PYYQnXXXXXXX P=F or A YY=year, n=quarter, missing=error
 	//purpose              FixedString(1)                  loan purpose: P (purch), C (cash out refi), N (rate/term refi) R (refi), missing=X
 	//term                 Int32                           loan term at origination, missing=-1
@@ -68,6 +72,7 @@ func ExampleLoad() {
 	//vintage              LowCardinality(FixedString(6))  vintage (from fpDt)
 	//propVal              Float32                         property value at origination
 	//standard             LowCardinality(String)          standard u/w process loan: Y, N
+	//sample               LowCardinality(String)          loan from the sample dataset: Y, N
 	//monthly.month        Array(Date)                     month of data, missing=1970/1/1
 	//monthly.upb          Array(Float32)                  unpaid balance, missing=-1
 	//monthly.dq           Array(Int32)                    months delinquent
@@ -90,6 +95,7 @@ func ExampleLoad() {
 	//zbDt                 Date                            zero balance date, missing=1970/1/1
 	//zbUpb                Float32                         UPB just prior to zero balance, missing=-1
 	//fileMonthly          String                          source file for monthly data
+	//runId                LowCardinality(String)          id of the run that loaded the loan, see the runs table
 	//fclMonth             Date                            month of foreclosure resolution
 	//fclProNet            Float32                         foreclosure net proceeds, missing=-1
 	//fclProMi             Float32                         foreclosure credit enhancement proceeds, missing=-1
@@ -104,7 +110,8 @@ func ExampleLoad() {
 	//mod.modMonth         Array(Date)                     month of modification
 	//mod.modCLoss         Array(Float32)                  current period modification loss, missing=-1
 	//mod.stepMod          Array(FixedString(1))           step mod flag: Y, N, missing=X
-	//qa.field             Array(LowCardinality(String))   failed qa: field name array
-	//qa.cntFail           Array(Int32)                    # months that failed qa
+	//qa.field             Array(LowCardinality(String))   failed qa: field or rule name array
+	//qa.reason            Array(LowCardinality(String))   failed qa: reason code array (see qa package)
+	//qa.cntFail           Array(Int32)                    # months that failed qa for the reason
 	//allFail              Array(LowCardinality(String))   fields that failed qa all months
 }
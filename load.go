@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/invertedv/freddie/metrics"
+	"github.com/invertedv/freddie/pipeline"
+	"github.com/invertedv/freddie/spec"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runLoad is the load command: it loads the quarters in -dir into -table.
+func runLoad(args []string) (err error) {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	conn := connFlags(fs)
+	srcDir := fs.String("dir", "", "string")
+	create := fs.String("create", "Y", "string")
+	table := fs.String("table", "", "string")
+	tmp := fs.String("tmp", "", "string")
+	nConcur := fs.Int("concur", 1, "int")
+	manifestTable := fs.String("manifest", "", "string")
+	resume := fs.String("resume", "N", "string")
+	from := fs.String("from", "", "string")
+	to := fs.String("to", "", "string")
+	quarters := fs.String("quarters", "", "string")
+	replace := fs.String("replace", "N", "string")
+	swapTable := fs.String("swap", "N", "string")
+	nParallel := fs.Int("quarters-parallel", 1, "int")
+	dry := fs.String("dry-run", "N", "string")
+	runsTable := fs.String("runs", "", "string")
+	lossThreshold := fs.Float64("loss-threshold", 0.001, "float64")
+	fingerprint := fs.String("fingerprint", "Y", "string")
+	metricsAddr := fs.String("metrics-addr", "", "string")
+	asOfDate := fs.String("as-of", "", "string")
+	specFile := fs.String("spec", "", "string")
+	cbsaDir := fs.String("cbsa", "", "string")
+	cbsaVintage := fs.String("cbsa-vintage", "", "string")
+	zip3File := fs.String("zip3", "", "string")
+	if e := parse(fs, conn, args); e != nil {
+		return e
+	}
+
+	// On SIGINT/SIGTERM, the running quarters stop, their temp tables are dropped and they are marked failed in the
+	// manifest.  A second signal kills the process.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		signal.Stop(sig)
+		lg.Warn("interrupted: stopping the running quarters, interrupt again to quit now")
+		cancel()
+	}()
+
+	if *metricsAddr != "" {
+		if e := metrics.Serve(*metricsAddr); e != nil {
+			return e
+		}
+		lg.Info("serving metrics", "addr", *metricsAddr)
+	}
+
+	var asOf time.Time
+	if *asOfDate != "" {
+		if asOf, err = time.Parse("2006-01-02", *asOfDate); err != nil {
+			return fmt.Errorf("bad -as-of %s, need form CCYY-MM-DD", *asOfDate)
+		}
+	}
+
+	var sp *spec.Spec
+	if *specFile != "" {
+		if sp, err = spec.Read(*specFile); err != nil {
+			return err
+		}
+	}
+
+	opts := []pipeline.Option{
+		pipeline.WithTarget(*table),
+		pipeline.WithTmpDB(*tmp),
+		pipeline.WithConcurrency(*nConcur, *nParallel),
+		pipeline.WithQuarters(*from, *to, *quarters),
+		pipeline.WithCreate(yes(*create)),
+		pipeline.WithResume(yes(*resume)),
+		pipeline.WithReplace(yes(*replace)),
+		pipeline.WithSwap(yes(*swapTable)),
+		pipeline.WithManifest(*manifestTable),
+		pipeline.WithRuns(*runsTable),
+		pipeline.WithLossThreshold(*lossThreshold),
+		pipeline.WithFingerprint(yes(*fingerprint)),
+		pipeline.WithAsOf(asOf),
+		pipeline.WithSpec(sp),
+		pipeline.WithCBSA(*cbsaDir, *cbsaVintage),
+		pipeline.WithZip3(*zip3File),
+		pipeline.WithRunInfo(version(), flagValues(fs)),
+		pipeline.WithLogger(lg),
+	}
+
+	if yes(*dry) {
+		return dryRun(ctx, pipeline.New(*srcDir, opts...))
+	}
+
+	// connect to ClickHouse.  The memory limits are shared by the quarters loading at once.
+	con, err := conn.connect(*nParallel)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := con.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	_, err = pipeline.New(*srcDir, append(opts, pipeline.WithConnect(con))...).Run(ctx)
+	return err
+}
@@ -0,0 +1,119 @@
+// Package logger writes structured log events.  An event has a time, a level, a message and key/value pairs.  It is
+// written as a line of text or, with format json, as a JSON object per line that a scheduler can parse.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels of an event
+const (
+	Info  = "info"
+	Warn  = "warn"
+	Error = "error"
+)
+
+// Formats of the output
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Logger writes events to an io.Writer.  It is safe for concurrent use.
+type Logger struct {
+	mu     sync.Mutex // mu serializes writes
+	w      io.Writer
+	format string
+}
+
+// New returns a Logger that writes to w in format (Text or JSON).
+func New(w io.Writer, format string) (*Logger, error) {
+	if format != Text && format != JSON {
+		return nil, fmt.Errorf("bad log format %s, need text or json", format)
+	}
+	return &Logger{w: w, format: format}, nil
+}
+
+// Info logs msg at level Info.  kv are key/value pairs.
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.Log(Info, msg, kv...)
+}
+
+// Warn logs msg at level Warn.  kv are key/value pairs.
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.Log(Warn, msg, kv...)
+}
+
+// Error logs msg at level Error.  kv are key/value pairs.
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.Log(Error, msg, kv...)
+}
+
+// Log logs msg at level.  kv are key/value pairs: the keys are strings.  Errors and durations are written as
+// strings.
+func (l *Logger) Log(level string, msg string, kv ...interface{}) {
+	now := time.Now()
+	if len(kv)%2 == 1 {
+		kv = append(kv, "!MISSING")
+	}
+	var b strings.Builder
+	if l.format == JSON {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, now.Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level)
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for ind := 0; ind < len(kv); ind += 2 {
+			b.WriteString(",")
+			writeJSON(&b, fmt.Sprint(kv[ind]))
+			b.WriteString(":")
+			writeJSON(&b, value(kv[ind+1]))
+		}
+		b.WriteString("}\n")
+	} else {
+		b.WriteString(now.Format("2006/01/02 15:04:05 "))
+		b.WriteString(strings.ToUpper(level))
+		b.WriteString(" ")
+		b.WriteString(msg)
+		for ind := 0; ind < len(kv); ind += 2 {
+			s := fmt.Sprint(value(kv[ind+1]))
+			if s == "" || strings.ContainsAny(s, " \t\"=") {
+				s = fmt.Sprintf("%q", s)
+			}
+			fmt.Fprintf(&b, " %v=%s", kv[ind], s)
+		}
+		b.WriteString("\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, b.String())
+}
+
+// value converts v to the value written
+func value(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case time.Duration:
+		return x.String()
+	case fmt.Stringer:
+		return x.String()
+	}
+	return v
+}
+
+// writeJSON writes the JSON encoding of v to b.  Values that can't be encoded are written as strings.
+func writeJSON(b *strings.Builder, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(j)
+}
@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("stage done", "quarter", "2010Q1", "rows", int64(12), "error", fmt.Errorf("bad \"x\""))
+	ev := make(map[string]interface{})
+	if e := json.Unmarshal(buf.Bytes(), &ev); e != nil {
+		t.Fatalf("bad json %s: %v", buf.String(), e)
+	}
+	if ev["msg"] != "stage done" || ev["level"] != Info || ev["quarter"] != "2010Q1" || ev["rows"] != 12.0 ||
+		ev["error"] != `bad "x"` {
+		t.Errorf("got %v", ev)
+	}
+
+	buf.Reset()
+	l, _ = New(&buf, Text)
+	l.Warn("lost rows", "quarter", "2010Q1", "msg", "3 of 10")
+	if !strings.HasSuffix(buf.String(), ` WARN lost rows quarter=2010Q1 msg="3 of 10"`+"\n") {
+		t.Errorf("got %q", buf.String())
+	}
+	if _, e := New(&buf, "xml"); e == nil {
+		t.Error("expected error for bad format")
+	}
+}
@@ -0,0 +1,130 @@
+// Package manifest records the progress of a load in a ClickHouse table.  There is one row for each quarter
+// loaded into a target table.  The row records the source files, row counts, status and timestamps.  A run that
+// dies part way through can be restarted and will skip the quarters that already finished.
+package manifest
+
+import (
+	"fmt"
+	"github.com/invertedv/chutils"
+	"strings"
+	"time"
+)
+
+// Status values for a quarter
+const (
+	Started = "started" // Started means the load of the quarter began but has not finished
+	Done    = "done"    // Done means the quarter was loaded successfully
+	Failed  = "failed"  // Failed means the load of the quarter returned an error
+)
+
+// Entry is the manifest row for a single quarter loaded into a target table.
+type Entry struct {
+	Target       string    // Target is the table the quarter is loaded into
+	Quarter      string    // Quarter is the quarter (e.g. 2010Q2) loaded
+	FileStatic   string    // FileStatic is the source file for the static data
+	FileMonthly  string    // FileMonthly is the source file for the monthly data
+	NStatic      int64     // NStatic is the # of rows loaded into the static temp table
+	NMonthly     int64     // NMonthly is the # of rows loaded into the monthly temp table
+	NLoans       int64     // NLoans is the # of loans inserted into Target
+	SHAStatic    string    // SHAStatic is the SHA-256 of the content of FileStatic
+	SHAMonthly   string    // SHAMonthly is the SHA-256 of the content of FileMonthly
+	SizeStatic   int64     // SizeStatic is the # of bytes of content of FileStatic
+	SizeMonthly  int64     // SizeMonthly is the # of bytes of content of FileMonthly
+	LinesStatic  int64     // LinesStatic is the # of lines in FileStatic
+	LinesMonthly int64     // LinesMonthly is the # of lines in FileMonthly
+	Status       string    // Status is one of Started, Done, Failed
+	Started      time.Time // Started is the time the load of the quarter began
+	Finished     time.Time // Finished is the time the load of the quarter ended (successfully or not)
+}
+
+// Create creates the manifest table, if it does not exist.  The engine is ReplacingMergeTree so that the most
+// recent entry for a target/quarter is the one that survives.
+func Create(table string, con *chutils.Connect) error {
+	qry := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    target String,
+    quarter String,
+    fileStatic String,
+    fileMonthly String,
+    nStatic Int64,
+    nMonthly Int64,
+    nLoans Int64,
+    status LowCardinality(String),
+    started DateTime,
+    finished DateTime,
+    shaStatic String,
+    shaMonthly String,
+    sizeStatic Int64,
+    sizeMonthly Int64,
+    linesStatic Int64,
+    linesMonthly Int64,
+    updated DateTime64(3)
+) ENGINE=ReplacingMergeTree(updated)
+ORDER BY (target, quarter)`, table)
+	_, err := con.Exec(qry)
+	return err
+}
+
+// columns are the columns of the manifest, other than updated
+const columns = `target, quarter, fileStatic, fileMonthly, nStatic, nMonthly, nLoans, status, started, finished,
+shaStatic, shaMonthly, sizeStatic, sizeMonthly, linesStatic, linesMonthly`
+
+// Reset removes all entries for target.  This is done when target is created from scratch.
+func Reset(table string, target string, con *chutils.Connect) error {
+	qry := fmt.Sprintf("ALTER TABLE %s DELETE WHERE target = $1 SETTINGS mutations_sync = 2", table)
+	_, err := con.Exec(qry, target)
+	return err
+}
+
+// Drop removes the entry for quarter from target.
+func Drop(table string, target string, quarter string, con *chutils.Connect) error {
+	qry := fmt.Sprintf("ALTER TABLE %s DELETE WHERE target = $1 AND quarter = $2 SETTINGS mutations_sync = 2", table)
+	_, err := con.Exec(qry, target, quarter)
+	return err
+}
+
+// Copy copies the entries for target "from" to target "to".  This is used when the table "from" becomes "to".
+func Copy(table string, from string, to string, con *chutils.Connect) error {
+	qry := fmt.Sprintf(`
+INSERT INTO %s (%s, updated)
+SELECT $1, %s, now64(3)
+FROM %s FINAL
+WHERE target = $2`, table, columns, strings.TrimPrefix(columns, "target, "), table)
+	_, err := con.Exec(qry, to, from)
+	return err
+}
+
+// Write adds e to the manifest.  It replaces any earlier entry for the same target and quarter.
+func Write(table string, e *Entry, con *chutils.Connect) error {
+	qry := fmt.Sprintf(`INSERT INTO %s (%s, updated)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, now64(3))`, table, columns)
+	_, err := con.Exec(qry, e.Target, e.Quarter, e.FileStatic, e.FileMonthly, e.NStatic, e.NMonthly, e.NLoans,
+		e.Status, e.Started, e.Finished, e.SHAStatic, e.SHAMonthly, e.SizeStatic, e.SizeMonthly, e.LinesStatic,
+		e.LinesMonthly)
+	return err
+}
+
+// Get returns the entries for target keyed by quarter.
+func Get(table string, target string, con *chutils.Connect) (map[string]*Entry, error) {
+	qry := fmt.Sprintf(`
+SELECT %s
+FROM %s FINAL
+WHERE target = $1`, columns, table)
+	rows, err := con.Query(qry, target)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	entries := make(map[string]*Entry)
+	for rows.Next() {
+		en := &Entry{}
+		if e := rows.Scan(&en.Target, &en.Quarter, &en.FileStatic, &en.FileMonthly, &en.NStatic, &en.NMonthly,
+			&en.NLoans, &en.Status, &en.Started, &en.Finished, &en.SHAStatic, &en.SHAMonthly, &en.SizeStatic,
+			&en.SizeMonthly, &en.LinesStatic, &en.LinesMonthly); e != nil {
+			return nil, e
+		}
+		entries[en.Quarter] = en
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,312 @@
+// Package metrics holds the Prometheus metrics of a load and serves them in the Prometheus text format.  The
+// metrics are package-level so the loaders can update them without passing them around.  They are:
+//
+//	freddie_rows_read_total{source}                 rows read from the source files
+//	freddie_rows_read_per_second{source,file}       rows per second read by each LoadRaw
+//	freddie_rows_written_total{source}              rows written by chutils.Export and chutils.Concur
+//	freddie_validation_failures_total{source,field} rows failing validation, by field
+//	freddie_current_quarter{quarter}                1 for each quarter being loaded
+//	freddie_join_query_seconds_total                seconds spent in the join query
+//	freddie_join_query_seconds{quarter}             seconds the join query of the quarter took
+//
+// source is static or monthly.
+package metrics
+
+import (
+	"fmt"
+	"github.com/invertedv/chutils"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of a Metric
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// Metric is a counter or gauge with labels.  It is safe for concurrent use.
+type Metric struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu   sync.Mutex        // protects vals and fns
+	vals map[string]*value // vals are the values, keyed by the label values joined with \xff
+	fns  map[string]func() float64
+}
+
+// value is a float64 that can be updated atomically
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(x float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		if atomic.CompareAndSwapUint64(&v.bits, old, math.Float64bits(math.Float64frombits(old)+x)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(x float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(x))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// registry holds the metrics in the order they are served
+var registry []*Metric
+
+// New returns a new Metric of type typ (Counter or Gauge) and adds it to the metrics served.
+func New(name, help, typ string, labels ...string) *Metric {
+	m := &Metric{name: name, help: help, typ: typ, labels: labels,
+		vals: make(map[string]*value), fns: make(map[string]func() float64)}
+	registry = append(registry, m)
+	return m
+}
+
+// the metrics of a load
+var (
+	RowsRead           = New("freddie_rows_read_total", "Rows read from the source files.", Counter, "source")
+	RowsPerSecond      = New("freddie_rows_read_per_second", "Rows per second read by each LoadRaw.", Gauge, "source", "file")
+	RowsWritten        = New("freddie_rows_written_total", "Rows written by chutils.Export and chutils.Concur.", Counter, "source")
+	ValidationFailures = New("freddie_validation_failures_total", "Rows failing validation, by field.", Counter, "source", "field")
+	CurrentQuarter     = New("freddie_current_quarter", "1 for each quarter being loaded.", Gauge, "quarter")
+	JoinSecondsTotal   = New("freddie_join_query_seconds_total", "Seconds spent in the join query.", Counter)
+	JoinSeconds        = New("freddie_join_query_seconds", "Seconds the join query of the quarter took.", Gauge, "quarter")
+)
+
+// with returns the value for the label values lvs, creating it if needed
+func (m *Metric) with(lvs []string) *value {
+	if len(lvs) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, need %d", m.name, len(lvs), len(m.labels)))
+	}
+	key := strings.Join(lvs, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.vals[key]
+	if !ok {
+		v = &value{}
+		m.vals[key] = v
+	}
+	return v
+}
+
+// Add adds x to the value for the label values lvs
+func (m *Metric) Add(x float64, lvs ...string) {
+	m.with(lvs).add(x)
+}
+
+// Set sets the value for the label values lvs to x
+func (m *Metric) Set(x float64, lvs ...string) {
+	m.with(lvs).set(x)
+}
+
+// SetFunc has the value for the label values lvs computed by fn when the metrics are served
+func (m *Metric) SetFunc(fn func() float64, lvs ...string) {
+	m.with(lvs)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fns[strings.Join(lvs, "\xff")] = fn
+}
+
+// Delete removes the value for the label values lvs
+func (m *Metric) Delete(lvs ...string) {
+	key := strings.Join(lvs, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.vals, key)
+	delete(m.fns, key)
+}
+
+// Value returns the value for the label values lvs
+func (m *Metric) Value(lvs ...string) float64 {
+	key := strings.Join(lvs, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if fn, ok := m.fns[key]; ok {
+		return fn()
+	}
+	if v, ok := m.vals[key]; ok {
+		return v.get()
+	}
+	return 0
+}
+
+// write writes m to w in the Prometheus text format
+func (m *Metric) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, e := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ); e != nil {
+		return e
+	}
+	keys := make([]string, 0, len(m.vals))
+	for k := range m.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		x := m.vals[k].get()
+		if fn, ok := m.fns[k]; ok {
+			x = fn()
+		}
+		if _, e := fmt.Fprintf(w, "%s%s %g\n", m.name, m.labelString(k), x); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// labelString returns the labels for key as {name="value",...}
+func (m *Metric) labelString(key string) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+	lvs := strings.Split(key, "\xff")
+	pairs := make([]string, len(lvs))
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for ind, lv := range lvs {
+		pairs[ind] = fmt.Sprintf(`%s="%s"`, m.labels[ind], esc.Replace(lv))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Write writes all the metrics to w in the Prometheus text format
+func Write(w io.Writer) error {
+	for _, m := range registry {
+		if e := m.write(w); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Serve serves the metrics at /metrics on addr (e.g. :9100) until the program exits.  It returns once addr is
+// being listened on.
+func Serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = Write(w)
+	})
+	go func() { _ = http.Serve(ln, mux) }()
+	return nil
+}
+
+// Load tracks the rows read and written by a LoadRaw
+type Load struct {
+	source string
+	file   string
+	start  time.Time
+	end    int64 // end is the UnixNano time the load finished, 0 while it runs
+	rows   int64 // rows is the # of rows read
+}
+
+// StartLoad starts tracking the LoadRaw of sourceFile.  source is static or monthly.
+func StartLoad(source, sourceFile string) *Load {
+	l := &Load{source: source, file: filepath.Base(sourceFile), start: time.Now()}
+	RowsPerSecond.SetFunc(l.rate, source, l.file)
+	return l
+}
+
+// rate returns the rows per second read.  Once the load is done, this is the rate over the whole load.
+func (l *Load) rate() float64 {
+	end := time.Now()
+	if e := atomic.LoadInt64(&l.end); e != 0 {
+		end = time.Unix(0, e)
+	}
+	secs := end.Sub(l.start).Seconds()
+	if secs <= 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&l.rows)) / secs
+}
+
+// Done stops the clock of the load
+func (l *Load) Done() {
+	atomic.StoreInt64(&l.end, time.Now().UnixNano())
+}
+
+// Input returns rdr with its rows and validation failures counted
+func (l *Load) Input(rdr chutils.Input) chutils.Input {
+	return &input{Input: rdr, l: l}
+}
+
+// Inputs returns rdrs with their rows and validation failures counted
+func (l *Load) Inputs(rdrs []chutils.Input) []chutils.Input {
+	out := make([]chutils.Input, len(rdrs))
+	for ind, r := range rdrs {
+		out[ind] = l.Input(r)
+	}
+	return out
+}
+
+// Output returns wrtr with its rows counted.  chutils.Export (as of chutils v1.1.10) reads a row at a time and
+// writes each with one Write, so each Write is a row.  TestOutput checks this.
+func (l *Load) Output(wrtr chutils.Output) chutils.Output {
+	return &output{Output: wrtr, l: l}
+}
+
+// Outputs returns wrtrs with their rows counted
+func (l *Load) Outputs(wrtrs []chutils.Output) []chutils.Output {
+	out := make([]chutils.Output, len(wrtrs))
+	for ind, w := range wrtrs {
+		out[ind] = l.Output(w)
+	}
+	return out
+}
+
+// input counts the rows read and the validation failures of an Input
+type input struct {
+	chutils.Input
+	l *Load
+}
+
+func (in *input) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	data, valid, err = in.Input.Read(nTarget, validate)
+	if len(data) == 0 {
+		return data, valid, err
+	}
+	atomic.AddInt64(&in.l.rows, int64(len(data)))
+	RowsRead.Add(float64(len(data)), in.l.source)
+	fds := in.TableSpec().FieldDefs
+	for _, v := range valid {
+		for ind, s := range v {
+			if s != chutils.VPass && s != chutils.VDefault && ind < len(fds) {
+				ValidationFailures.Add(1, in.l.source, fds[ind].Name)
+			}
+		}
+	}
+	return data, valid, err
+}
+
+// output counts the rows written to an Output
+type output struct {
+	chutils.Output
+	l *Load
+}
+
+func (o *output) Write(b []byte) (int, error) {
+	n, err := o.Output.Write(b)
+	if err == nil {
+		RowsWritten.Add(1, o.l.source)
+	}
+	return n, err
+}
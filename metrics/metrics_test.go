@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/file"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	m := &Metric{name: "test_rows_total", help: "Rows.", typ: Counter, labels: []string{"source"},
+		vals: make(map[string]*value), fns: make(map[string]func() float64)}
+	m.Add(2, "static")
+	m.Add(3, "static")
+	m.Add(1, `a"b`)
+	m.SetFunc(func() float64 { return 7 }, "monthly")
+
+	var b bytes.Buffer
+	if e := m.write(&b); e != nil {
+		t.Fatal(e)
+	}
+	want := []string{
+		"# HELP test_rows_total Rows.",
+		"# TYPE test_rows_total counter",
+		`test_rows_total{source="a\"b"} 1`,
+		`test_rows_total{source="monthly"} 7`,
+		`test_rows_total{source="static"} 5`,
+	}
+	if got := strings.TrimSpace(b.String()); got != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+
+	m.Delete("monthly")
+	if v := m.Value("monthly"); v != 0 {
+		t.Errorf("deleted value: got %g, want 0", v)
+	}
+}
+
+// buffer is a chutils.Output that counts its Writes
+type buffer struct {
+	bytes.Buffer
+	writes int
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.writes++
+	return b.Buffer.Write(p)
+}
+func (b *buffer) Name() string    { return "buffer" }
+func (b *buffer) Insert() error   { return nil }
+func (b *buffer) Separator() rune { return ',' }
+func (b *buffer) EOL() rune       { return '\n' }
+func (b *buffer) Text() string    { return "'" }
+func (b *buffer) Close() error    { return nil }
+
+func TestOutput(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rows.txt")
+	if e := os.WriteFile(name, []byte("a|1\nb|2\nc|3\n"), 0600); e != nil {
+		t.Fatal(e)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr := file.NewReader(name, '|', '\n', '"', 0, 0, 0, f, 1000)
+	defer func() { _ = rdr.Close() }()
+	fds := map[int]*chutils.FieldDef{
+		0: {Name: "x", ChSpec: chutils.ChField{Base: chutils.ChString}, Legal: chutils.NewLegalValues()},
+		1: {Name: "y", ChSpec: chutils.ChField{Base: chutils.ChString}, Legal: chutils.NewLegalValues()},
+	}
+	rdr.SetTableSpec(chutils.NewTableDef("x", chutils.MergeTree, fds))
+
+	l := StartLoad("test", name)
+	defer l.Done()
+	b := &buffer{}
+	if e := chutils.Export(l.Input(rdr), l.Output(b), -1, false); e != nil {
+		t.Fatal(e)
+	}
+	if b.writes != 3 {
+		t.Errorf("Export wrote 3 rows with %d Writes", b.writes)
+	}
+	if v := RowsWritten.Value("test"); v != 3 {
+		t.Errorf("rows written: got %g, want 3", v)
+	}
+}
@@ -2,79 +2,255 @@
 package monthly
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"github.com/invertedv/chutils"
 	"github.com/invertedv/chutils/file"
 	"github.com/invertedv/chutils/nested"
 	s "github.com/invertedv/chutils/sql"
-	"os"
+	"github.com/invertedv/freddie/metrics"
+	"github.com/invertedv/freddie/qa"
+	"github.com/invertedv/freddie/source"
+	"github.com/invertedv/freddie/spec"
+	"io"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // TableDef is TableDef for the monthly table.  It is exported as other packages (e.g. joined) may need fields from
 // it (e.g. Description)
-var TableDef *chutils.TableDef
+var TableDef = tableDef()
 
-// LoadRaw loads the raw monthly series from sourceFile into "table".  The table is created/reset if create=true.
-// The file is loaded using nConcur concurrent processes.  con is the ClickHouse connector.
-func LoadRaw(sourceFile string, table string, create bool, nConcur int, con *chutils.Connect) (err error) {
-	fileName = sourceFile
+// tableDef returns the TableDef of the fields in the source file plus the fields LoadRaw adds.  It is used for the
+// field descriptions, so the as-of date of the date bounds doesn't matter.
+func tableDef() *chutils.TableDef {
+	td := build(time.Time{})
+	for _, fd := range xtraFields() {
+		td.FieldDefs[len(td.FieldDefs)] = fd
+	}
+	return td
+}
+
+// Spec returns the built-in spec of the fields of the source file
+func Spec() map[string]*spec.Field {
+	return spec.FromTableDef(build(time.Time{}))
+}
 
-	f, err := os.Open(fileName)
+// LoadRaw loads the raw monthly series from sourceFile into "table".  The table is created/reset if create=true.
+// The file is loaded using nConcur concurrent processes.  set holds the as-of date, the latest legal date (e.g. of
+// month), and the spec that overrides the validation of build.  con is the ClickHouse connector.  sourceFile may be
+// compressed and/or within a zip archive (see package source).  If ctx is cancelled, reading stops and ctx.Err()
+// is returned.
+func LoadRaw(ctx context.Context, sourceFile string, table string, create bool, nConcur int, set *spec.Settings,
+	con *chutils.Connect) (err error) {
+	rdrsn, nWorker, rdr, err := readers(ctx, sourceFile, nConcur, set)
 	if err != nil {
 		return err
 	}
-	rdr := file.NewReader(fileName, '|', '\n', '"', 0, 0, 0, f, 6000000)
-	rdr.Skip = 0
 	defer func() {
 		// don't throw an error if we already have one
 		if e := rdr.Close(); e != nil && err == nil {
 			err = e
 		}
 	}()
-	// rdr is the base reader the slice of readers is based on
-	rdr.SetTableSpec(build())
 
-	// build slice of readers
-	rdrs, err := file.Rdrs(rdr, nConcur)
-	if err != nil {
-		return
+	if create {
+		if err = rdrsn[0].TableSpec().Create(con, table); err != nil {
+			closeRdrs(rdrsn)
+			return err
+		}
 	}
 
 	var wrtrs []chutils.Output
 	// build a slice of writers
 	if wrtrs, err = s.Wrtrs(table, nConcur, con); err != nil {
+		closeRdrs(rdrsn)
 		return
 	}
 
-	newCalcs := make([]nested.NewCalcFn, 0)
-	newCalcs = append(newCalcs, fField, dqField, reoField, vField)
+	ld := metrics.StartLoad("monthly", sourceFile)
+	defer ld.Done()
+	err = chutils.Concur(nWorker, ld.Inputs(rdrsn), ld.Outputs(wrtrs), 400000)
+	// Concur doesn't pass along the read error
+	if e := ctx.Err(); e != nil && err != nil {
+		return e
+	}
+	return
+}
 
-	// rdrsn is a slice of nested readers -- needed since we are adding fields to the raw data
-	rdrsn := make([]chutils.Input, 0)
-	for j, r := range rdrs {
+// DryRun reads and validates sourceFile, including the fields LoadRaw adds, without loading it.  The file is read
+// using nConcur concurrent processes.  set is as for LoadRaw.  The validation results of each field are returned.
+func DryRun(ctx context.Context, sourceFile string, nConcur int, set *spec.Settings) (prof *qa.Profile, err error) {
+	rdrsn, _, rdr, err := readers(ctx, sourceFile, nConcur, set)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		closeRdrs(rdrsn)
+		if e := rdr.Close(); e != nil && err == nil {
+			prof, err = nil, e
+		}
+	}()
+	return qa.Run(ctx, rdrsn)
+}
+
+// readers returns nConcur nested readers that divide sourceFile among them and add the extra fields.  nWorker is
+// the # of workers chutils.Concur should use.  The file reader the readers are based on is also returned -- the
+// caller must close it.
+func readers(ctx context.Context, sourceFile string, nConcur int, set *spec.Settings) (rdrsn []chutils.Input, nWorker int, rdr *file.Reader, err error) {
+	f, err := source.Open(ctx, sourceFile)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	// base is the reader the slice of readers is based on
+	base := file.NewReader(sourceFile, '|', '\n', '"', 0, 0, 0, f, bufSize)
+	base.Skip = 0
+	defer func() {
+		if err != nil {
+			_ = base.Close()
+		}
+	}()
+	td := build(set.AsOf)
+	if set.Spec != nil {
+		if err = spec.Apply(td, set.Spec.Monthly); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+	base.SetTableSpec(td)
 
-		rn, e := nested.NewReader(r, xtraFields(), newCalcs)
+	rdrs, nWorker, err := splitRdrs(ctx, f, base, nConcur)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	// rdrsn is a slice of nested readers -- needed since we are adding fields to the raw data.  Each validates its
+	// rows with a qa.Reasons, so it knows why a field fails.
+	rdrsn = make([]chutils.Input, 0)
+	for j, r := range rdrs {
+		rr := qa.NewReasons(r)
+		newCalcs := make([]nested.NewCalcFn, 0)
+		newCalcs = append(newCalcs, fField(sourceFile), dqField, reoField, vField(rr))
+		rn, e := nested.NewReader(rr, xtraFields(), newCalcs)
 		if e != nil {
-			return e
+			closeRdrs(rdrs)
+			return nil, 0, nil, e
 		}
 		if j == 0 {
 			if e := rn.TableSpec().Check(); e != nil {
-				return e
-			}
-			if create {
-				if err = rn.TableSpec().Create(con, table); err != nil {
-					return err
-				}
+				closeRdrs(rdrs)
+				return nil, 0, nil, e
 			}
 		}
 		rdrsn = append(rdrsn, rn)
 	}
-	TableDef = rdrsn[0].TableSpec()
+	return rdrsn, nWorker, base, nil
+}
 
-	err = chutils.Concur(12, rdrsn, wrtrs, 400000)
-	return
+// bufSize is the size of the read buffer of the file readers
+const bufSize = 6000000
+
+// splitRdrs returns nRdrs readers that split the data of rdr0, which reads f, among them.  nWorker is the # of
+// workers chutils.Concur should use.  A stream can't be split by line number, so its lines are dealt out to the
+// readers.  All the readers must run at once in that case.
+func splitRdrs(ctx context.Context, f io.ReadSeekCloser, rdr0 *file.Reader,
+	nRdrs int) (rdrs []chutils.Input, nWorker int, err error) {
+	if source.Seekable(f) {
+		rdrs, err = rdrsSource(ctx, rdr0, nRdrs)
+		return rdrs, 12, err
+	}
+	rdrs, err = rdrsFan(ctx, rdr0, nRdrs)
+	return rdrs, nRdrs, err
+}
+
+// rdrsSource generates a slice of nRdrs readers that split the lines of rdr0 among them.  Each opens the source
+// with source.Open, unlike file.Rdrs, so that a cancelled ctx stops its reads.
+func rdrsSource(ctx context.Context, rdr0 *file.Reader, nRdrs int) (r []chutils.Input, err error) {
+	if nRdrs < 1 {
+		return nil, chutils.Wrapper(chutils.ErrInput, "must have >= 1 reader")
+	}
+	nObs, err := rdr0.CountLines()
+	if err != nil {
+		return nil, err
+	}
+	// close the readers opened so far on error
+	defer func() {
+		if err != nil {
+			closeRdrs(r)
+			r = nil
+		}
+	}()
+	nper := nObs / nRdrs
+	start := 1
+	for ind := 0; ind < nRdrs; ind++ {
+		var f io.ReadSeekCloser
+		if f, err = source.Open(ctx, rdr0.Name()); err != nil {
+			return r, err
+		}
+		np := start + nper - 1
+		if ind == nRdrs-1 {
+			np = 0
+		}
+		x := file.NewReader(rdr0.Name(), rdr0.Separator(), rdr0.EOL(), rdr0.Quote, rdr0.Width, rdr0.Skip, np, f, bufSize)
+		x.SetTableSpec(rdr0.TableSpec())
+		r = append(r, x)
+		if err = x.Seek(start); err != nil {
+			return r, err
+		}
+		start += nper
+	}
+	return r, nil
+}
+
+// rdrsFan generates a slice of nRdrs readers that divide the data of rdr0 among them.  This is used instead of
+// rdrsSource when the source is a stream (e.g. compressed), since rdrsSource needs to seek within the source.
+func rdrsFan(ctx context.Context, rdr0 *file.Reader, nRdrs int) (r []chutils.Input, err error) {
+	fs, err := source.Fan(ctx, rdr0.Name(), nRdrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fs {
+		x := file.NewReader(rdr0.Name(), rdr0.Separator(), rdr0.EOL(), rdr0.Quote, rdr0.Width, rdr0.Skip, 0, f, bufSize)
+		x.SetTableSpec(rdr0.TableSpec())
+		r = append(r, x)
+	}
+	return r, nil
+}
+
+// LastMonth returns the last day of the latest month (field 1, CCYYMM) in the monthly file sourceFile.  For the
+// latest quarter of a release, this is the end of the data of the release.
+func LastMonth(ctx context.Context, sourceFile string) (time.Time, error) {
+	f, err := source.Open(ctx, sourceFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	last := ""
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1<<20), 1<<20)
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), "|", 3)
+		if len(fields) > 1 && len(fields[1]) == 6 && fields[1] > last {
+			last = fields[1]
+		}
+	}
+	if e := sc.Err(); e != nil {
+		return time.Time{}, e
+	}
+	month, err := time.Parse("200601", last)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: no months found", sourceFile)
+	}
+	return month.AddDate(0, 1, -1), nil
+}
+
+// closeRdrs closes rdrs.  chutils.Concur closes its readers, so this is for errors before it runs.
+func closeRdrs(rdrs []chutils.Input) {
+	for _, r := range rdrs {
+		_ = r.Close()
+	}
 }
 
 // xtraFields defines additional fields for the nested reader
@@ -82,7 +258,7 @@ func xtraFields() (fds []*chutils.FieldDef) {
 	vfd := &chutils.FieldDef{
 		Name:        "qaMonthly",
 		ChSpec:      chutils.ChField{Base: chutils.ChString, Funcs: chutils.OuterFuncs{chutils.OuterLowCardinality}},
-		Description: "validation results for each field: 0=pass, 1=fail",
+		Description: "fields that failed validation, with reason codes: :field=reason:",
 		Legal:       chutils.NewLegalValues(),
 		Missing:     "!",
 		Width:       0,
@@ -115,28 +291,19 @@ func xtraFields() (fds []*chutils.FieldDef) {
 	return
 }
 
-// vField returns the validation results for each field -- 0 = pass, 1 = fail in a string which has a  keyval format
-func vField(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
-	res := make([]byte, 0)
-	res = append(res, []byte(":")...)
-	for ind, v := range valid {
-		name := td.FieldDefs[ind].Name
-		if v != chutils.VPass && v != chutils.VDefault {
-			res = append(res, []byte(name+":")...)
-		}
-	}
-	if len(res) > 1 {
-		return string(res), nil
+// vField returns a function that returns the validation results in a string which has a keyval format: each
+// field that fails, with its reason code (see qa.Failures).  rr is the reader of the row, which has its reason codes.
+func vField(rr *qa.Reasons) nested.NewCalcFn {
+	return func(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
+		return qa.Failures(td, valid, rr.Next(), nil), nil
 	}
-	return "", nil
 }
 
-// fileName is global since used as a closure to fField
-var fileName string
-
-// fField returns the name of the file we're loading
-func fField(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
-	return fileName, nil
+// fField returns a function that returns the name of the file we're loading
+func fField(fileName string) nested.NewCalcFn {
+	return func(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
+		return fileName, nil
+	}
 }
 
 // dqField returns the delinquency level as an integer
@@ -165,11 +332,12 @@ func reoField(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, valid
 	return res, nil
 }
 
-// build builds the TableDef for the monthly field files.
-func build() *chutils.TableDef {
+// build builds the TableDef for the monthly field files.  asOf is the latest legal date of month, zbDt, lpDt and
+// the other dates.
+func build(asOf time.Time) *chutils.TableDef {
 	var (
 		minDt   = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
-		maxDt   = time.Now()
+		maxDt   = asOf
 		missDt  = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
 		strMiss = "X"
 
@@ -0,0 +1,98 @@
+package monthly
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/file"
+	"github.com/invertedv/freddie/source"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLastMonth(t *testing.T) {
+	lines := "F110Q1000001|202206|190000|0\nF110Q1000001|202209|189000|0\nF110Q1000002|202207|95000|0\n"
+	name := filepath.Join(t.TempDir(), "historical_data_time_2022Q2.txt")
+	if e := os.WriteFile(name, []byte(lines), 0600); e != nil {
+		t.Fatal(e)
+	}
+	got, err := LastMonth(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2022, 9, 30, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// the file must be bigger than the read buffers of the readers, so they go back to it after the cancel
+	name := filepath.Join(t.TempDir(), "historical_data_time_2022Q2.txt")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	nLines := 4 * bufSize / 20
+	for ind := 0; ind < nLines; ind++ {
+		_, _ = fmt.Fprintf(w, "F122Q%07d|202206\n", ind)
+	}
+	if e := w.Flush(); e != nil {
+		t.Fatal(e)
+	}
+	if e := f.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs, err := source.Open(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := file.NewReader(name, '|', '\n', '"', 0, 0, 0, fs, bufSize)
+	defer func() { _ = base.Close() }()
+	fds := map[int]*chutils.FieldDef{
+		0: {Name: "lnId", ChSpec: chutils.ChField{Base: chutils.ChString}},
+		1: {Name: "month", ChSpec: chutils.ChField{Base: chutils.ChString}},
+	}
+	base.SetTableSpec(chutils.NewTableDef("lnId", chutils.MergeTree, fds))
+	rdrs, _, err := splitRdrs(ctx, fs, base, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeRdrs(rdrs)
+
+	nRead := 0
+	for _, r := range rdrs {
+		data, _, e := r.Read(1, false)
+		if e != nil {
+			t.Fatal(e)
+		}
+		nRead += len(data)
+	}
+	cancel()
+	for _, r := range rdrs {
+		for {
+			data, _, e := r.Read(10000, false)
+			nRead += len(data)
+			if e == io.EOF {
+				t.Fatalf("read to the end of the file after the cancel")
+			}
+			if e != nil {
+				if !errors.Is(e, chutils.ErrInput) {
+					t.Errorf("got %v, want a read error", e)
+				}
+				break
+			}
+		}
+	}
+	if nRead >= nLines {
+		t.Errorf("read all %d lines after the cancel", nLines)
+	}
+}
@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"fmt"
+	"github.com/invertedv/freddie/logger"
+	"github.com/invertedv/freddie/source"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FilePair holds the two files of a quarter: one for static data, one for monthly data
+type FilePair struct {
+	Static  string
+	Monthly string
+}
+
+// Ignored is a file FindFiles skips, and why
+type Ignored struct {
+	File   string
+	Reason string
+}
+
+// historicalRe matches the quarterly files of the standard and non-standard (excl) datasets, e.g.
+// historical_data_2010Q1.txt and historical_data_excl_time_2010Q1.txt.gz.  Older releases put the quarter
+// first: historical_data1_Q12010.txt.  The submatches are excl, time, and the year and quarter in either order.
+var historicalRe = regexp.MustCompile(
+	`^historical_data1?_(excl_)?(time_)?(?:([0-9]{4})Q([1-4])|Q([1-4])([0-9]{4}))\.txt(\.gz|\.zst)?$`)
+
+// sampleRe matches the files of the sample dataset.  The submatches are the type (orig=static, svcg=monthly) and
+// the year.
+var sampleRe = regexp.MustCompile(`^sample_(orig|svcg)_([0-9]{4})\.txt(\.gz|\.zst)?$`)
+
+// recognize returns the key (quarter CCYYQn or, for the sample dataset, year CCYY) of the Freddie file base.
+// monthly is true if it holds monthly data.  ok is false if base is not a Freddie file.
+func recognize(base string) (key string, monthly bool, ok bool) {
+	if m := historicalRe.FindStringSubmatch(base); m != nil {
+		if m[3] != "" {
+			return m[3] + "Q" + m[4], m[2] != "", true
+		}
+		return m[6] + "Q" + m[5], m[2] != "", true
+	}
+	if m := sampleRe.FindStringSubmatch(base); m != nil {
+		return m[2], m[1] == "svcg", true
+	}
+	return "", false, false
+}
+
+// FindFiles returns the static and monthly files in srcDir, and its subdirectories, keyed by quarter.  The files
+// of the sample dataset (sample_orig_CCYY.txt, sample_svcg_CCYY.txt) are keyed by year.  Freddie's zip archives are
+// searched for the text files they hold.  Files that are not Freddie files, and zip archives that can't be read,
+// are returned in ignored.  It is an error for two files to have the same quarter and type (e.g. the standard and
+// excl static files of a quarter).
+func FindFiles(srcDir string) (fileList map[string]*FilePair, ignored []Ignored, err error) {
+	names := make([]string, 0)
+	err = filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(d.Name(), ".zip") {
+			entries, e := source.Entries(path)
+			if e != nil {
+				// a bad archive shouldn't stop the others from loading
+				ignored = append(ignored, Ignored{File: path, Reason: e.Error()})
+				return nil
+			}
+			names = append(names, entries...)
+			return nil
+		}
+		names = append(names, path)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading directory %s: %v", srcDir, err)
+	}
+
+	fileList = make(map[string]*FilePair)
+	for _, name := range names {
+		key, monthly, ok := recognize(filepath.Base(name))
+		if !ok {
+			ignored = append(ignored, Ignored{File: name, Reason: "not a Freddie Mac file"})
+			continue
+		}
+		if fileList[key] == nil {
+			fileList[key] = new(FilePair)
+		}
+		file := &fileList[key].Static
+		if monthly {
+			file = &fileList[key].Monthly
+		}
+		if *file != "" {
+			return nil, nil, fmt.Errorf("%s and %s are both files for %s -- point -dir at one dataset", *file, name, key)
+		}
+		*file = name
+	}
+	return fileList, ignored, nil
+}
+
+// Unpaired returns the files in fileList that are missing their static or monthly mate
+func Unpaired(fileList map[string]*FilePair) []string {
+	files := make([]string, 0)
+	for _, v := range fileList {
+		if v.Static == "" {
+			files = append(files, v.Monthly)
+		}
+		if v.Monthly == "" {
+			files = append(files, v.Static)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// reportFiles logs the files that were ignored or are unpaired
+func reportFiles(lg *logger.Logger, fileList map[string]*FilePair, ignored []Ignored) {
+	for _, f := range ignored {
+		lg.Warn("ignoring file", "file", f.File, "reason", f.Reason)
+	}
+	for _, f := range Unpaired(fileList) {
+		lg.Warn("unpaired file: no matching static or monthly file", "file", f)
+	}
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"historical_data_2010Q1.txt",
+		"historical_data_time_2010Q1.txt.gz",
+		"excl/historical_data_excl_2010Q2.txt",
+		"excl/historical_data_excl_time_2010Q2.txt",
+		"old/historical_data1_Q32009.txt",
+		"old/historical_data1_time_Q32009.txt",
+		"sample/sample_orig_2015.txt",
+		"sample/sample_svcg_2015.txt",
+		"historical_data_2011Q4.txt",
+		"README.txt",
+		"x.txt",
+		"bad.zip",
+	}
+	for _, f := range files {
+		name := filepath.Join(dir, f)
+		if e := os.MkdirAll(filepath.Dir(name), 0700); e != nil {
+			t.Fatal(e)
+		}
+		if e := os.WriteFile(name, nil, 0600); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	fileList, ignored, err := FindFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*FilePair{
+		"2010Q1": {Static: filepath.Join(dir, files[0]), Monthly: filepath.Join(dir, files[1])},
+		"2010Q2": {Static: filepath.Join(dir, files[2]), Monthly: filepath.Join(dir, files[3])},
+		"2009Q3": {Static: filepath.Join(dir, files[4]), Monthly: filepath.Join(dir, files[5])},
+		"2015":   {Static: filepath.Join(dir, files[6]), Monthly: filepath.Join(dir, files[7])},
+		"2011Q4": {Static: filepath.Join(dir, files[8])},
+	}
+	if !reflect.DeepEqual(fileList, want) {
+		t.Errorf("got file list %v", fileList)
+	}
+	// bad.zip is empty, so it can't be read
+	if len(ignored) != 3 || ignored[0].File != filepath.Join(dir, "bad.zip") || ignored[0].Reason == "" ||
+		ignored[1].File != filepath.Join(dir, "README.txt") || ignored[2].File != filepath.Join(dir, "x.txt") {
+		t.Errorf("got ignored %v", ignored)
+	}
+	if u := Unpaired(fileList); !reflect.DeepEqual(u, []string{filepath.Join(dir, files[8])}) {
+		t.Errorf("got unpaired %v", u)
+	}
+
+	keys, err := QuarterKeys(fileList, "2009Q4", "", "")
+	if err == nil {
+		t.Errorf("expected error for unpaired 2011Q4, got %v", keys)
+	}
+	keys, err = QuarterKeys(fileList, "2009Q4", "2010", "")
+	if err != nil || !reflect.DeepEqual(keys, []string{"2010Q1", "2010Q2"}) {
+		t.Errorf("got keys %v, %v", keys, err)
+	}
+
+	// the standard and excl files of a quarter can't be mixed
+	if e := os.WriteFile(filepath.Join(dir, "historical_data_excl_2010Q1.txt"), nil, 0600); e != nil {
+		t.Fatal(e)
+	}
+	if _, _, e := FindFiles(dir); e == nil {
+		t.Error("expected error for two static files for 2010Q1")
+	}
+}
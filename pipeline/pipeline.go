@@ -0,0 +1,786 @@
+// Package pipeline loads the Freddie Mac data into ClickHouse.  It finds the static and monthly files of each
+// quarter in a directory, selects the quarters to load and loads them, in chronological order, into a single
+// table.  Each quarter is tracked in a manifest table and each run in a runs table.
+//
+// A Pipeline is configured with options:
+//
+//	p := pipeline.New("/data/freddie", pipeline.WithConnect(con), pipeline.WithTarget("mtg.freddie"),
+//		pipeline.WithTmpDB("tmp"), pipeline.WithConcurrency(12, 2), pipeline.WithQuarters("2010Q1", "2012Q4", ""))
+//	results, err := p.Run(ctx)
+//
+// Run returns the result of each quarter: its files, what was done with it, its row counts and fingerprints.
+// DryRun reads and validates the files without ClickHouse.  Cancelling ctx stops the quarters in progress; their
+// temp tables are dropped and the manifest marks them failed.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/freddie/cbsa"
+	"github.com/invertedv/freddie/joined"
+	"github.com/invertedv/freddie/logger"
+	"github.com/invertedv/freddie/manifest"
+	"github.com/invertedv/freddie/metrics"
+	"github.com/invertedv/freddie/monthly"
+	"github.com/invertedv/freddie/qa"
+	"github.com/invertedv/freddie/runs"
+	"github.com/invertedv/freddie/source"
+	"github.com/invertedv/freddie/spec"
+	"github.com/invertedv/freddie/static"
+	"github.com/invertedv/freddie/zip3"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions taken on a quarter
+const (
+	Load    = "load"    // Load loads the quarter
+	Replace = "replace" // Replace deletes the loans of the quarter from the table, then loads it
+	Retry   = "retry"   // Retry reloads a quarter the manifest shows as started or failed
+	Skip    = "skip"    // Skip skips a quarter the manifest shows as done
+)
+
+// Pipeline loads the quarters in a source directory into a ClickHouse table.  Create one with New.
+type Pipeline struct {
+	srcDir        string
+	con           *chutils.Connect
+	table         string
+	tmpDB         string
+	nConcur       int
+	nParallel     int
+	from, to      string
+	quarters      string
+	create        bool
+	resume        bool
+	replace       bool
+	swap          bool
+	manifestTable string
+	runsTable     string
+	lossThreshold float64
+	fingerprint   bool
+	asOf          time.Time
+	spec          *spec.Spec
+	cbsaDir       string
+	cbsaVintage   string
+	zip3File      string
+	version       string
+	flags         map[string]string
+	lg            *logger.Logger
+}
+
+// Option sets an option of a Pipeline
+type Option func(p *Pipeline)
+
+// New returns a Pipeline that loads the files in srcDir, and its subdirectories.  The defaults are: create the
+// table, 1 concurrent process per monthly file, 1 quarter at a time, all quarters in srcDir, a loss threshold of
+// 0.001, fingerprint the files and no logging.
+func New(srcDir string, opts ...Option) *Pipeline {
+	lg, _ := logger.New(io.Discard, logger.Text)
+	p := &Pipeline{srcDir: srcDir, nConcur: 1, nParallel: 1, create: true, lossThreshold: 0.001, fingerprint: true,
+		version: "unknown", lg: lg}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.nParallel < 1 {
+		p.nParallel = 1
+	}
+	if p.manifestTable == "" {
+		p.manifestTable = p.table + "_manifest"
+	}
+	if p.runsTable == "" {
+		p.runsTable = p.table + "_runs"
+	}
+	return p
+}
+
+// WithConnect sets the ClickHouse connection.  Its memory limits are shared by the quarters loading at once.
+func WithConnect(con *chutils.Connect) Option {
+	return func(p *Pipeline) { p.con = con }
+}
+
+// WithTarget sets the table to load
+func WithTarget(table string) Option {
+	return func(p *Pipeline) { p.table = table }
+}
+
+// WithTmpDB sets the database for the temp tables.  Each quarter uses its own temp tables.
+func WithTmpDB(db string) Option {
+	return func(p *Pipeline) { p.tmpDB = db }
+}
+
+// WithConcurrency sets the # of concurrent processes that load each monthly file (nConcur) and the # of quarters
+// loaded at once (nParallel).
+func WithConcurrency(nConcur, nParallel int) Option {
+	return func(p *Pipeline) { p.nConcur, p.nParallel = nConcur, nParallel }
+}
+
+// WithQuarters selects the quarters to load: those within [from, to] and, if list is not empty, in list, a
+// comma-separated list of quarters.  Empty bounds are ignored.  See SelectQuarters.
+func WithQuarters(from, to, list string) Option {
+	return func(p *Pipeline) { p.from, p.to, p.quarters = from, to, list }
+}
+
+// WithCreate sets whether the table is created/reset.  It is not reset if a resumed run has quarters done.
+func WithCreate(create bool) Option {
+	return func(p *Pipeline) { p.create = create }
+}
+
+// WithResume sets whether the quarters the manifest shows as done are skipped.  The others are retried.
+func WithResume(resume bool) Option {
+	return func(p *Pipeline) { p.resume = resume }
+}
+
+// WithReplace sets whether the loans of a quarter already in the table are deleted before the quarter is loaded
+func WithReplace(replace bool) Option {
+	return func(p *Pipeline) { p.replace = replace }
+}
+
+// WithSwap sets whether the quarters are loaded into <table>_staging, which replaces the table once all quarters
+// are loaded.
+func WithSwap(swap bool) Option {
+	return func(p *Pipeline) { p.swap = swap }
+}
+
+// WithManifest sets the manifest table.  Default: <table>_manifest.
+func WithManifest(table string) Option {
+	return func(p *Pipeline) { p.manifestTable = table }
+}
+
+// WithRuns sets the runs table.  Default: <table>_runs.
+func WithRuns(table string) Option {
+	return func(p *Pipeline) { p.runsTable = table }
+}
+
+// WithLossThreshold sets the fraction of rows a quarter may lose at a stage of the load before it fails
+func WithLossThreshold(threshold float64) Option {
+	return func(p *Pipeline) { p.lossThreshold = threshold }
+}
+
+// WithFingerprint sets whether the SHA-256, size and line count of each file are recorded in the manifest.  This
+// reads each file a second time, on top of the load.  Without it, the losses from the files to the temp tables
+// aren't checked.
+func WithFingerprint(fingerprint bool) Option {
+	return func(p *Pipeline) { p.fingerprint = fingerprint }
+}
+
+// WithAsOf sets the as-of date: the latest legal date when the files are validated (e.g. of fpDt and month).  By
+// default, it is inferred from the data: the last month in the monthly file of the latest quarter in the source
+// directory (see monthly.LastMonth), so a release gives the same results whenever it is loaded.
+func WithAsOf(asOf time.Time) Option {
+	return func(p *Pipeline) { p.asOf = asOf }
+}
+
+// WithSpec sets the validation spec that overrides the built-in one (see package spec)
+func WithSpec(sp *spec.Spec) Option {
+	return func(p *Pipeline) { p.spec = sp }
+}
+
+// WithCBSA sets the directory of the CBSA delineation files that give the legal msaD codes and their names (see
+// package cbsa).  If vintage is empty, the latest vintage in force by the as-of date is used.  By default, msaD is
+// checked against the list built into the static package.
+func WithCBSA(dir string, vintage string) Option {
+	return func(p *Pipeline) { p.cbsaDir, p.cbsaVintage = dir, vintage }
+}
+
+// WithZip3 sets the zip3 crosswalk file that checks the state of each loan against its zip and gives zip3State
+// (see package zip3).  By default, there is none.
+func WithZip3(file string) Option {
+	return func(p *Pipeline) { p.zip3File = file }
+}
+
+// WithRunInfo sets the version of the loader and the settings recorded in the runs table
+func WithRunInfo(version string, flags map[string]string) Option {
+	return func(p *Pipeline) { p.version, p.flags = version, flags }
+}
+
+// WithLogger sets the logger for the progress of the load
+func WithLogger(lg *logger.Logger) Option {
+	return func(p *Pipeline) { p.lg = lg }
+}
+
+// Result is the result of a quarter
+type Result struct {
+	Quarter   string
+	Files     FilePair
+	Settings  *spec.Settings // Settings are the as-of date, spec and reference data the files were validated with
+	Action    string         // Action is Load, Replace, Retry or Skip
+	Counts    *joined.Counts // Counts are the rows at each stage of the load
+	Static    *source.Info   // Static is the fingerprint of the static file
+	Monthly   *source.Info   // Monthly is the fingerprint of the monthly file
+	Warnings  []string       // Warnings are the rows lost at each stage, within the loss threshold
+	StaticQA  *qa.Profile    // StaticQA is the validation profile of the static file (DryRun only)
+	MonthlyQA *qa.Profile    // MonthlyQA is the validation profile of the monthly file (DryRun only)
+	Started   time.Time
+	Finished  time.Time
+	Err       error // Err is the error that stopped the quarter, if any
+}
+
+// Files finds the files in the source directory and selects the quarters, in chronological order.  The files
+// that are ignored or unpaired are logged.
+func (p *Pipeline) Files() (fileList map[string]*FilePair, keys []string, err error) {
+	fileList, ignored, err := FindFiles(p.srcDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	reportFiles(p.lg, fileList, ignored)
+	if keys, err = QuarterKeys(fileList, p.from, p.to, p.quarters); err != nil {
+		return nil, nil, err
+	}
+	return fileList, keys, nil
+}
+
+// AsOf returns the as-of date: the one set by WithAsOf or, if none was, the one inferred from the files in
+// fileList.
+func (p *Pipeline) AsOf(ctx context.Context, fileList map[string]*FilePair) (time.Time, error) {
+	if !p.asOf.IsZero() {
+		return p.asOf, nil
+	}
+	// the latest quarter.  A year of the sample dataset runs through its Q4.
+	latest, latestQtr := "", ""
+	for k, v := range fileList {
+		qtr := k
+		if len(k) == 4 {
+			qtr += "Q4"
+		}
+		if v.Monthly != "" && qtr > latestQtr {
+			latest, latestQtr = k, qtr
+		}
+	}
+	if latest == "" {
+		return time.Time{}, fmt.Errorf("no monthly files to infer the as-of date from")
+	}
+	asOf, err := monthly.LastMonth(ctx, fileList[latest].Monthly)
+	if err != nil {
+		return time.Time{}, err
+	}
+	p.lg.Info("inferred as-of date", "asOf", asOf.Format("2006-01-02"), "file", fileList[latest].Monthly)
+	return asOf, nil
+}
+
+// settings returns the settings the files in fileList are validated with: the as-of date, the spec, the CBSA
+// delineation and the zip3 crosswalk.
+func (p *Pipeline) settings(ctx context.Context, fileList map[string]*FilePair) (*spec.Settings, error) {
+	asOf, err := p.AsOf(ctx, fileList)
+	if err != nil {
+		return nil, err
+	}
+	set := &spec.Settings{AsOf: asOf, Spec: p.spec}
+	if p.cbsaDir == "" && p.cbsaVintage != "" {
+		return nil, fmt.Errorf("pipeline: CBSA vintage %s with no CBSA directory", p.cbsaVintage)
+	}
+	if p.cbsaDir != "" {
+		if set.CBSA, err = cbsa.Find(p.cbsaDir, p.cbsaVintage, asOf); err != nil {
+			return nil, err
+		}
+		p.lg.Info("cbsa delineation", "vintage", set.CBSA.Vintage, "file", set.CBSA.File, "areas", len(set.CBSA.Areas))
+	}
+	if p.zip3File != "" {
+		if set.Zip3, err = zip3.Read(p.zip3File); err != nil {
+			return nil, err
+		}
+		p.lg.Info("zip3 crosswalk", "file", set.Zip3.File, "zip3s", len(set.Zip3.States))
+	}
+	return set, nil
+}
+
+// DryRun reads and validates the files of the selected quarters without ClickHouse.  The results hold the
+// validation profiles of each quarter.
+func (p *Pipeline) DryRun(ctx context.Context) ([]*Result, error) {
+	fileList, keys, err := p.Files()
+	if err != nil {
+		return nil, err
+	}
+	set, err := p.settings(ctx, fileList)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*Result, len(keys))
+	ind := make(map[string]int)
+	for i, k := range keys {
+		results[i] = &Result{Quarter: k, Files: *fileList[k], Settings: set}
+		ind[k] = i
+	}
+
+	var mu sync.Mutex // protects nDone
+	nDone := 0
+	p.lg.Info("dry run", "quarters", len(keys))
+	check := func(k string) error {
+		r := results[ind[k]]
+		r.Started = time.Now()
+		if r.StaticQA, r.Err = static.DryRun(ctx, r.Files.Static, set); r.Err == nil {
+			r.MonthlyQA, r.Err = monthly.DryRun(ctx, r.Files.Monthly, p.nConcur, set)
+		}
+		r.Finished = time.Now()
+		if r.Err != nil {
+			return fmt.Errorf("quarter %s: %w", k, r.Err)
+		}
+		mu.Lock()
+		nDone++
+		p.lg.Info("quarter checked", "quarter", k, "done", nDone, "of", len(keys), "staticRows", r.StaticQA.Rows,
+			"monthlyRows", r.MonthlyQA.Rows)
+		mu.Unlock()
+		return nil
+	}
+	return results, Parallel(ctx, keys, p.nParallel, check)
+}
+
+// Run loads the selected quarters.  The first quarter to fail stops the run; the quarters running finish first.
+// The results are in chronological order, one per quarter selected.  Quarters that were not reached have an
+// empty Started.
+func (p *Pipeline) Run(ctx context.Context) (results []*Result, err error) {
+	if p.con == nil {
+		return nil, fmt.Errorf("pipeline: no ClickHouse connection")
+	}
+	if p.table == "" {
+		return nil, fmt.Errorf("pipeline: no target table")
+	}
+	fileList, keys, err := p.Files()
+	if err != nil {
+		return nil, err
+	}
+	set, err := p.settings(ctx, fileList)
+	if err != nil {
+		return nil, err
+	}
+	con := p.con
+
+	// record the run.  The row is rewritten at the end with how it went.
+	if e := runs.Create(p.runsTable, con); e != nil {
+		return nil, e
+	}
+	run := runs.NewRun(p.table, p.version, p.flags)
+	run.AsOf, run.SpecVersion, run.CBSAVintage = set.AsOf, set.Version(), set.CBSAVintage()
+	if e := runs.Write(p.runsTable, run, con); e != nil {
+		return nil, e
+	}
+	p.lg.Info("run started", "runId", run.RunID, "target", p.table, "version", run.Version, "asOf",
+		set.AsOf.Format("2006-01-02"), "spec", run.SpecVersion, "cbsa", run.CBSAVintage)
+	defer func() {
+		run.Finished, run.Status = time.Now(), runs.Done
+		if err != nil {
+			run.Status, run.Error = runs.Failed, err.Error()
+		}
+		if e := runs.Write(p.runsTable, run, con); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	createTable := p.create
+
+	// target is the table the quarters are loaded into
+	target := p.table
+	if p.swap {
+		target = p.table + "_staging"
+	}
+
+	// find the quarters that are already loaded
+	if e := manifest.Create(p.manifestTable, con); e != nil {
+		return nil, e
+	}
+	entries := make(map[string]*manifest.Entry)
+	if p.resume {
+		if entries, err = manifest.Get(p.manifestTable, target, con); err != nil {
+			return nil, err
+		}
+		// don't reset the table if we're picking up where we left off
+		for _, v := range entries {
+			if v.Status == manifest.Done {
+				createTable = false
+			}
+		}
+	}
+	if createTable && len(entries) == 0 {
+		if e := manifest.Reset(p.manifestTable, target, con); e != nil {
+			return nil, e
+		}
+	}
+	// the staging table starts as a copy of the table, if we're adding to it
+	if p.swap && !createTable && len(entries) == 0 {
+		if e := manifest.Reset(p.manifestTable, target, con); e != nil {
+			return nil, e
+		}
+		exists, e := joined.Exists(p.table, con)
+		if e != nil {
+			return nil, e
+		}
+		createTable = !exists
+		if exists {
+			p.lg.Info("copying table to staging", "table", p.table, "staging", target)
+			if e := joined.Copy(p.table, target, con); e != nil {
+				return nil, e
+			}
+			if e := manifest.Copy(p.manifestTable, p.table, target, con); e != nil {
+				return nil, e
+			}
+		}
+	}
+
+	// the plan
+	results = make([]*Result, len(keys))
+	byKey := make(map[string]*Result)
+	p.lg.Info("plan", "quarters", len(keys), "target", target)
+	for ind, k := range keys {
+		r := &Result{Quarter: k, Files: *fileList[k], Settings: set, Action: Load}
+		if p.replace {
+			r.Action = Replace
+		}
+		if v, ok := entries[k]; ok {
+			r.Action = Retry
+			if v.Status == manifest.Done {
+				r.Action = Skip
+			}
+		}
+		results[ind], byKey[k] = r, r
+		p.lg.Info("plan quarter", "quarter", k, "action", r.Action, "static", r.Files.Static, "monthly", r.Files.Monthly)
+	}
+
+	// quarters to load
+	todo := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if byKey[k].Action == Skip {
+			p.lg.Info("skipping quarter", "quarter", k, "loaded", entries[k].Finished.Format("2006/1/2 15:04"))
+			continue
+		}
+		todo = append(todo, k)
+	}
+
+	// the bytes of the files to load, for the ETA
+	prog := &progress{start: time.Now(), size: make(map[string]int64)}
+	for _, k := range todo {
+		for _, f := range []string{fileList[k].Static, fileList[k].Monthly} {
+			n, e := source.Size(f)
+			if e != nil {
+				return nil, e
+			}
+			prog.size[f] = n
+			prog.total += n
+		}
+	}
+
+	var mu sync.Mutex // protects nDone and run.Quarters
+	nDone := 0
+	// load loads quarter k into target. If create is true, target is created.
+	load := func(k string, create bool) error {
+		r := byKey[k]
+		r.Started = time.Now()
+		err := p.loadQuarter(ctx, r, run.RunID, target, create, prog)
+		r.Finished = time.Now()
+		if err != nil {
+			r.Err = err
+			return err
+		}
+		mu.Lock()
+		nDone++
+		run.Quarters[k] = r.Finished.Sub(r.Started).Minutes()
+		p.lg.Info("quarter done", "quarter", k, "done", nDone, "of", len(todo), "minutes",
+			round(r.Finished.Sub(r.Started).Minutes()), "staticRows", r.Counts.Static, "monthlyRows", r.Counts.Monthly,
+			"loans", r.Counts.Loans)
+		mu.Unlock()
+		return nil
+	}
+
+	start := time.Now()
+	// the first quarter creates the table, so it has to finish before the others start
+	if createTable && len(todo) > 0 {
+		if e := load(todo[0], true); e != nil {
+			return results, e
+		}
+		todo = todo[1:]
+	}
+	if e := Parallel(ctx, todo, p.nParallel, func(k string) error { return load(k, false) }); e != nil {
+		return results, e
+	}
+
+	// all quarters are in, so readers can now see the new table
+	if p.swap {
+		if e := joined.Swap(target, p.table, con); e != nil {
+			return results, e
+		}
+		if e := manifest.Reset(p.manifestTable, p.table, con); e != nil {
+			return results, e
+		}
+		if e := manifest.Copy(p.manifestTable, target, p.table, con); e != nil {
+			return results, e
+		}
+		if e := manifest.Reset(p.manifestTable, target, con); e != nil {
+			return results, e
+		}
+		p.lg.Info("swapped staging into table", "staging", target, "table", p.table)
+	}
+	p.lg.Info("run done", "runId", run.RunID, "hours", round(time.Since(start).Hours()))
+	return results, nil
+}
+
+// loadQuarter loads the quarter of r into target, filling in r.  If create is true, target is created.
+func (p *Pipeline) loadQuarter(ctx context.Context, r *Result, runID, target string, create bool, prog *progress) error {
+	k, con := r.Quarter, p.con
+	// a quarter that failed may have inserted some rows, so it is replaced
+	if r.Action == Retry {
+		p.lg.Info("retrying quarter", "quarter", k)
+	}
+	if (r.Action == Replace || r.Action == Retry) && !create {
+		if e := joined.Delete(target, k, joined.Standard(r.Files.Static), con); e != nil {
+			return e
+		}
+	}
+	metrics.CurrentQuarter.Set(1, k)
+	defer metrics.CurrentQuarter.Delete(k)
+	entry := &manifest.Entry{Target: target, Quarter: k, FileStatic: r.Files.Static,
+		FileMonthly: r.Files.Monthly, Status: manifest.Started, Started: r.Started}
+	if e := manifest.Write(p.manifestTable, entry, con); e != nil {
+		return e
+	}
+	// fingerprint the files while they load.  Without fingerprints, the files have 0 lines, which CheckLoss skips.
+	qctx, qcancel := context.WithCancel(ctx)
+	defer qcancel()
+	fps := make(chan *fingerprints, 1)
+	if p.fingerprint {
+		go func() { fps <- fingerprint(qctx, &r.Files) }()
+	} else {
+		fps <- &fingerprints{static: &source.Info{}, monthly: &source.Info{}}
+	}
+
+	// stage logs each stage of the load along with the progress of the run
+	stage := func(name string, rows int64, elapsed time.Duration) {
+		kv := []interface{}{"quarter", k, "stage", name, "rows", rows, "seconds", round(elapsed.Seconds())}
+		switch name {
+		case joined.StageStatic:
+			kv = append(kv, prog.add(r.Files.Static)...)
+		case joined.StageMonthly:
+			kv = append(kv, prog.add(r.Files.Monthly)...)
+		case joined.StageInsert:
+			metrics.JoinSecondsTotal.Add(elapsed.Seconds())
+			metrics.JoinSeconds.Set(elapsed.Seconds(), k)
+		}
+		p.lg.Info("stage done", kv...)
+	}
+	cnts, e := joined.Load(ctx, runID, r.Files.Monthly, r.Files.Static, target, p.tmpDB, create, p.nConcur, r.Settings,
+		stage, con)
+	if e != nil {
+		qcancel()
+	}
+	fp := <-fps
+	if e == nil {
+		e = fp.err
+	}
+	if e == nil {
+		r.Counts, r.Static, r.Monthly = cnts, fp.static, fp.monthly
+		entry.NStatic, entry.NMonthly, entry.NLoans = cnts.Static, cnts.Monthly, cnts.Loans
+		entry.SHAStatic, entry.SizeStatic, entry.LinesStatic = fp.static.SHA256, fp.static.Size, fp.static.Lines
+		entry.SHAMonthly, entry.SizeMonthly, entry.LinesMonthly = fp.monthly.SHA256, fp.monthly.Size, fp.monthly.Lines
+		r.Warnings, e = CheckLoss(entry, p.lossThreshold)
+		for _, w := range r.Warnings {
+			p.lg.Warn("rows lost", "quarter", k, "detail", w)
+		}
+	}
+	entry.Finished = time.Now()
+	if e != nil {
+		entry.Status = manifest.Failed
+		if e1 := manifest.Write(p.manifestTable, entry, con); e1 != nil {
+			p.lg.Error("manifest write failed", "quarter", k, "error", e1)
+		}
+		if errors.Is(e, context.Canceled) {
+			return fmt.Errorf("quarter %s interrupted: %w", k, e)
+		}
+		return fmt.Errorf("quarter %s: %w", k, e)
+	}
+	entry.Status = manifest.Done
+	return manifest.Write(p.manifestTable, entry, con)
+}
+
+// progress tracks the bytes of the source files loaded, to estimate the time left
+type progress struct {
+	mu    sync.Mutex       // protects done
+	start time.Time        // start is when the load started
+	size  map[string]int64 // size is the # of bytes of each file to load
+	total int64            // total is the # of bytes of all the files to load
+	done  int64            // done is the # of bytes of the files loaded so far
+}
+
+// add adds file to the files loaded.  It returns key/value pairs for the log: the percent of bytes done and the
+// estimated seconds left.
+func (p *progress) add(file string) []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += p.size[file]
+	if p.done == 0 || p.total == 0 {
+		return nil
+	}
+	frac := float64(p.done) / float64(p.total)
+	eta := time.Since(p.start).Seconds() * (1 - frac) / frac
+	return []interface{}{"bytesDone", p.done, "bytesTotal", p.total, "pctDone", round(100 * frac), "etaSeconds", round(eta)}
+}
+
+// round rounds x to 2 decimals for the log
+func round(x float64) float64 {
+	return math.Round(100*x) / 100
+}
+
+// fingerprints are the fingerprints of the files of a quarter
+type fingerprints struct {
+	static  *source.Info
+	monthly *source.Info
+	err     error
+}
+
+// fingerprint returns the fingerprints of the files of fp
+func fingerprint(ctx context.Context, fp *FilePair) *fingerprints {
+	st, err := source.Fingerprint(ctx, fp.Static)
+	if err != nil {
+		return &fingerprints{err: err}
+	}
+	mo, err := source.Fingerprint(ctx, fp.Monthly)
+	if err != nil {
+		return &fingerprints{err: err}
+	}
+	return &fingerprints{static: st, monthly: mo}
+}
+
+// CheckLoss compares the row counts of a quarter at each stage of the load: the lines of the files, the rows of
+// the temp tables and the loans joined.  If the fraction of rows lost at any stage exceeds threshold, an error is
+// returned.  Otherwise, each loss is returned as a warning.  A stage that starts with 0 rows, e.g. a file that
+// wasn't fingerprinted, isn't checked.
+func CheckLoss(en *manifest.Entry, threshold float64) (warnings []string, err error) {
+	stages := []struct {
+		name     string
+		from, to int64
+	}{
+		{"static file to static table", en.LinesStatic, en.NStatic},
+		{"monthly file to monthly table", en.LinesMonthly, en.NMonthly},
+		{"static table to loans joined", en.NStatic, en.NLoans},
+	}
+	for _, st := range stages {
+		if st.to >= st.from {
+			continue
+		}
+		frac := float64(st.from-st.to) / float64(st.from)
+		msg := fmt.Sprintf("%s lost %d of %d rows (%0.4f%%)", st.name, st.from-st.to, st.from, 100*frac)
+		if frac > threshold {
+			return nil, fmt.Errorf("%s, more than the loss threshold %g", msg, threshold)
+		}
+		warnings = append(warnings, msg)
+	}
+	return warnings, nil
+}
+
+// QuarterKeys returns the quarters of fileList selected by from, to and list (see SelectQuarters) in
+// chronological order.  Each quarter must have both a static and a monthly file.
+func QuarterKeys(fileList map[string]*FilePair, from string, to string, list string) ([]string, error) {
+	keys := make([]string, 0, len(fileList))
+	for k := range fileList {
+		keys = append(keys, k)
+	}
+	keys, err := SelectQuarters(keys, from, to, list)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	// Check we got pairs
+	for _, k := range keys {
+		if fileList[k].Monthly == "" || fileList[k].Static == "" {
+			return nil, fmt.Errorf("quarter %s is missing static or monthly", k)
+		}
+	}
+	return keys, nil
+}
+
+// QuarterRe matches a quarter of the form CCYYQn or, for the sample dataset, a year CCYY
+var QuarterRe = regexp.MustCompile(`^[0-9]{4}(Q[1-4])?$`)
+
+// SelectQuarters returns the quarters in keys that are within [from, to].  If list is not empty, the quarters
+// must also be in list, a comma-separated list of quarters.  Empty bounds are ignored.  A year (from the sample
+// dataset) is within [from, to] if any of its quarters are.
+func SelectQuarters(keys []string, from string, to string, list string) ([]string, error) {
+	for _, q := range []string{from, to} {
+		if q != "" && !QuarterRe.MatchString(q) {
+			return nil, fmt.Errorf("bad quarter %s, need form CCYYQn or CCYY", q)
+		}
+	}
+	if len(from) == 4 {
+		from += "Q1"
+	}
+	if len(to) == 4 {
+		to += "Q4"
+	}
+	want := make(map[string]bool)
+	if list != "" {
+		for _, q := range strings.Split(list, ",") {
+			q = strings.TrimSpace(q)
+			if !QuarterRe.MatchString(q) {
+				return nil, fmt.Errorf("bad quarter %s, need form CCYYQn or CCYY", q)
+			}
+			want[q] = true
+		}
+	}
+
+	sel := make([]string, 0, len(keys))
+	for _, k := range keys {
+		first, last := k, k
+		if len(k) == 4 {
+			first, last = k+"Q1", k+"Q4"
+		}
+		if (from != "" && last < from) || (to != "" && first > to) {
+			continue
+		}
+		if list != "" && !want[k] {
+			continue
+		}
+		delete(want, k)
+		sel = append(sel, k)
+	}
+	for q := range want {
+		return nil, fmt.Errorf("quarter %s is not in the source directory or is outside -from/-to", q)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("no quarters selected")
+	}
+	return sel, nil
+}
+
+// Parallel runs fn on each quarter in quarters, running up to nParallel at once.  Once fn fails or ctx is
+// cancelled, no more are started.  The error of the first failure is returned after the running calls finish.
+func Parallel(ctx context.Context, quarters []string, nParallel int, fn func(quarter string) error) error {
+	if nParallel < 1 {
+		nParallel = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // protects firstErr
+		firstErr error
+	)
+	sem := make(chan struct{}, nParallel)
+	for _, k := range quarters {
+		sem <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(k string) {
+			defer func() { <-sem; wg.Done() }()
+			if e := fn(k); e != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = e
+				}
+				mu.Unlock()
+			}
+		}(k)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
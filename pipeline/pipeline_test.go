@@ -0,0 +1,26 @@
+package pipeline
+
+import (
+	"github.com/invertedv/freddie/manifest"
+	"testing"
+)
+
+func TestCheckLoss(t *testing.T) {
+	en := &manifest.Entry{LinesStatic: 10000, NStatic: 10000, LinesMonthly: 500000, NMonthly: 500000, NLoans: 9995}
+	warnings, err := CheckLoss(en, 0.001)
+	if err != nil || len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v %v", warnings, err)
+	}
+	if _, e := CheckLoss(en, 0.0001); e == nil {
+		t.Fatal("expected loss of 5 loans to exceed threshold")
+	}
+	en.NLoans = 10000
+	if warnings, e := CheckLoss(en, 0); e != nil || len(warnings) != 0 {
+		t.Fatalf("expected no loss, got %v %v", warnings, e)
+	}
+	// without fingerprints, the lines of the files are 0 and those stages aren't checked
+	en.LinesStatic, en.LinesMonthly = 0, 0
+	if warnings, e := CheckLoss(en, 0); e != nil || len(warnings) != 0 {
+		t.Fatalf("expected no loss without fingerprints, got %v %v", warnings, e)
+	}
+}
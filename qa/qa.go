@@ -0,0 +1,133 @@
+// Package qa summarizes the validation results of the static and monthly data.  A Profile counts, for each field,
+// the rows that passed validation, used the default value because the field was empty, or failed.
+package qa
+
+import (
+	"context"
+	"fmt"
+	"github.com/invertedv/chutils"
+	"io"
+	"sync"
+	"text/tabwriter"
+)
+
+// Counts holds the validation results of a single field
+type Counts struct {
+	Pass    int64 // Pass is the # of rows for which the field passed validation
+	Default int64 // Default is the # of rows for which the field was empty and the default was used
+	Fail    int64 // Fail is the # of rows for which the field had an illegal value or the wrong type
+}
+
+// Profile holds the validation results of a source
+type Profile struct {
+	Rows   int64    // Rows is the # of rows read
+	Fields []string // Fields are the names of the fields, in the order of the TableDef
+	Counts []Counts // Counts are the results for each field in Fields
+}
+
+// NewProfile returns an empty Profile for the fields of td
+func NewProfile(td *chutils.TableDef) *Profile {
+	p := &Profile{Fields: make([]string, len(td.FieldDefs)), Counts: make([]Counts, len(td.FieldDefs))}
+	for ind := 0; ind < len(td.FieldDefs); ind++ {
+		p.Fields[ind] = td.FieldDefs[ind].Name
+	}
+	return p
+}
+
+// Add adds the validation results of a row
+func (p *Profile) Add(valid chutils.Valid) {
+	p.Rows++
+	for ind, v := range valid {
+		if ind >= len(p.Counts) {
+			break
+		}
+		switch v {
+		case chutils.VPass:
+			p.Counts[ind].Pass++
+		case chutils.VDefault:
+			p.Counts[ind].Default++
+		default:
+			p.Counts[ind].Fail++
+		}
+	}
+}
+
+// Merge adds the results of q, which must be for the same fields, to p
+func (p *Profile) Merge(q *Profile) {
+	p.Rows += q.Rows
+	for ind := range p.Counts {
+		p.Counts[ind].Pass += q.Counts[ind].Pass
+		p.Counts[ind].Default += q.Counts[ind].Default
+		p.Counts[ind].Fail += q.Counts[ind].Fail
+	}
+}
+
+// Write writes a table of the results for each field to w
+func (p *Profile) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "field\tpass\tdefault\tfail\t")
+	for ind, name := range p.Fields {
+		c := p.Counts[ind]
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t\n", name, c.Pass, c.Default, c.Fail)
+	}
+	return tw.Flush()
+}
+
+// Run reads rdrs to the end, concurrently, validating each row.  The results are merged into a single Profile.
+// The readers must have the same TableDef.
+func Run(ctx context.Context, rdrs []chutils.Input) (*Profile, error) {
+	if len(rdrs) == 0 {
+		return nil, fmt.Errorf("no readers")
+	}
+	profs := make([]*Profile, len(rdrs))
+	errs := make([]error, len(rdrs))
+	var wg sync.WaitGroup
+	for ind, r := range rdrs {
+		wg.Add(1)
+		go func(ind int, r chutils.Input) {
+			defer wg.Done()
+			profs[ind], errs[ind] = profile(ctx, r)
+		}(ind, r)
+	}
+	wg.Wait()
+
+	prof := NewProfile(rdrs[0].TableSpec())
+	for ind := range rdrs {
+		if errs[ind] != nil {
+			return nil, errs[ind]
+		}
+		prof.Merge(profs[ind])
+	}
+	return prof, nil
+}
+
+// blockRows is the # of rows profile reads at a time
+const blockRows = 1000
+
+// profile reads rdr to the end, validating each row.
+func profile(ctx context.Context, rdr chutils.Input) (*Profile, error) {
+	prof := NewProfile(rdr.TableSpec())
+	for {
+		data, valid, err := rdr.Read(blockRows, true)
+		for _, v := range valid {
+			prof.Add(v)
+		}
+		if err == io.EOF {
+			// nested.Reader returns io.EOF if a read fails before any data
+			if e := ctx.Err(); e != nil {
+				return nil, e
+			}
+			return prof, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// nested.Reader returns no data and no error if the underlying read fails
+		if len(data) == 0 {
+			if e := ctx.Err(); e != nil {
+				return nil, e
+			}
+			return nil, fmt.Errorf("read failed after %d rows", prof.Rows)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package qa
+
+import (
+	"github.com/invertedv/chutils"
+	"strings"
+	"time"
+)
+
+// Reason codes: why a field fails validation
+const (
+	Empty = "empty" // Empty is a field that is empty and has no default
+	Parse = "parse" // Parse is a field that can't be parsed as its type, e.g. a letter in a number
+	Long  = "long"  // Long is a string that is longer than its FixedString
+	Low   = "low"   // Low is a value below the minimum
+	High  = "high"  // High is a value above the maximum
+	Range = "range" // Range is a value out of range, when the value itself is not known (calculated fields)
+	Level = "level" // Level is a value that is not one of the legal levels
+	Rule  = "rule"  // Rule is a row that fails a cross-field rule
+)
+
+// Reason returns the reason code of a field with FieldDef fd and value raw, as read, that got status when it was
+// validated.  It is "" if the field passed or took its default.
+func Reason(fd *chutils.FieldDef, raw interface{}, status chutils.Status) string {
+	str, isStr := raw.(string)
+	switch {
+	case status == chutils.VPass || status == chutils.VDefault:
+		return ""
+	case isStr && strings.TrimSpace(str) == "":
+		return Empty
+	case status == chutils.VTypeFail && isStr && fd.ChSpec.Base == chutils.ChFixedString && len(str) > fd.ChSpec.Length:
+		return Long
+	case status == chutils.VTypeFail:
+		return Parse
+	}
+	if fd.Legal != nil && len(fd.Legal.Levels) > 0 {
+		return Level
+	}
+	if fd.Legal == nil {
+		return Range
+	}
+	// convert raw to the type of the field to compare it to the minimum
+	v, st := fd.ChSpec.Converter(raw, fd.Missing, nil)
+	if st != chutils.VPass {
+		return Range
+	}
+	if less(v, fd.Legal.LowLimit) {
+		return Low
+	}
+	return High
+}
+
+// Status returns the reason code of a field with FieldDef fd that got status when it was validated, when its value
+// as read is not known.
+func Status(fd *chutils.FieldDef, status chutils.Status) string {
+	switch status {
+	case chutils.VPass, chutils.VDefault:
+		return ""
+	case chutils.VTypeFail:
+		return Parse
+	}
+	if fd.Legal != nil && len(fd.Legal.Levels) > 0 {
+		return Level
+	}
+	return Range
+}
+
+// less returns true if a < b.  a and b have the same type; if not, or the type isn't ordered, it is false.
+func less(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case int32:
+		y, ok := b.(int32)
+		return ok && x < y
+	case int64:
+		y, ok := b.(int64)
+		return ok && x < y
+	case float32:
+		y, ok := b.(float32)
+		return ok && x < y
+	case float64:
+		y, ok := b.(float64)
+		return ok && x < y
+	case time.Time:
+		y, ok := b.(time.Time)
+		return ok && x.Before(y)
+	}
+	return false
+}
+
+// Reasons is a chutils.Input that validates the rows of its Input itself, so it knows why each field fails.  The
+// reason codes of the rows of the last Read are kept.  Next returns them, a row at a time, in order -- it is meant
+// to be called by a nested.NewCalcFn, which the nested reader calls once for each row.
+type Reasons struct {
+	chutils.Input
+	rows [][]string // rows are the reason codes of the fields of each row of the last Read
+	next int        // next is the row Next returns
+}
+
+// NewReasons returns a Reasons that reads rdr
+func NewReasons(rdr chutils.Input) *Reasons {
+	return &Reasons{Input: rdr}
+}
+
+// Read reads nTarget rows from the Input.  If validate is true, the rows are validated and their reason codes are
+// kept for Next.
+func (r *Reasons) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	data, _, err = r.Input.Read(nTarget, false)
+	r.rows, r.next = nil, 0
+	if !validate {
+		return data, nil, err
+	}
+	fds := r.TableSpec().FieldDefs
+	valid = make([]chutils.Valid, len(data))
+	r.rows = make([][]string, len(data))
+	for i, row := range data {
+		valid[i] = make(chutils.Valid, len(row))
+		r.rows[i] = make([]string, len(row))
+		for j, raw := range row {
+			row[j], valid[i][j] = fds[j].Validator(raw)
+			r.rows[i][j] = Reason(fds[j], raw, valid[i][j])
+		}
+	}
+	return data, valid, err
+}
+
+// Next returns the reason codes of the fields of the next row of the last Read, "" for a field that passed.  It
+// is nil once the rows are used up.
+func (r *Reasons) Next() []string {
+	if r.next >= len(r.rows) {
+		return nil
+	}
+	r.next++
+	return r.rows[r.next-1]
+}
+
+// Failures returns the fields of a row that failed validation, with their reason codes, in the format of qaStatic
+// and qaMonthly: ":field=reason:field=reason:".  reasons are the reason codes of the fields read (see Reasons).
+// Fields past them, which are calculated, get theirs from Status.  rules are the cross-field rules the row fails,
+// which have the reason code Rule.  It is "" if nothing failed.
+func Failures(td *chutils.TableDef, valid chutils.Valid, reasons []string, rules []string) string {
+	res := make([]byte, 0)
+	res = append(res, []byte(":")...)
+	for ind, v := range valid {
+		if v == chutils.VPass || v == chutils.VDefault {
+			continue
+		}
+		fd := td.FieldDefs[ind]
+		reason := Status(fd, v)
+		if ind < len(reasons) && reasons[ind] != "" {
+			reason = reasons[ind]
+		}
+		res = append(res, []byte(fd.Name+"="+reason+":")...)
+	}
+	for _, name := range rules {
+		res = append(res, []byte(name+"="+Rule+":")...)
+	}
+	if len(res) > 1 {
+		return string(res)
+	}
+	return ""
+}
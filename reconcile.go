@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/invertedv/freddie/joined"
+	"github.com/invertedv/freddie/pipeline"
+	"github.com/invertedv/freddie/source"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// runReconcile is the reconcile command: it compares the loans and UPB of each quarter in -table with Freddie's
+// published figures in -published.  If -dir is given, the lnIds of each quarter that fall out of the table are
+// listed by the stage at which they fall out.
+func runReconcile(args []string) (err error) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	conn := connFlags(fs)
+	table := fs.String("table", "", "string")
+	published := fs.String("published", "", "string")
+	srcDir := fs.String("dir", "", "string")
+	from := fs.String("from", "", "string")
+	to := fs.String("to", "", "string")
+	quarters := fs.String("quarters", "", "string")
+	if e := parse(fs, conn, args); e != nil {
+		return e
+	}
+
+	pub, err := readPublished(*published)
+	if err != nil {
+		return err
+	}
+
+	con, err := conn.connect(1)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := con.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	loaded, err := joined.QuarterTotals(*table, con)
+	if err != nil {
+		return err
+	}
+	if e := writeTotals(os.Stdout, pub, loaded); e != nil {
+		return e
+	}
+	if *srcDir == "" {
+		return nil
+	}
+
+	fileList, keys, err := pipeline.New(*srcDir, pipeline.WithQuarters(*from, *to, *quarters), pipeline.WithLogger(lg)).Files()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nquarter,standard,stage,lnId")
+	ctx := context.Background()
+	for _, k := range keys {
+		standard := joined.Standard(fileList[k].Static)
+		static, e := fileLoanIDs(ctx, fileList[k].Static, staticIDField)
+		if e != nil {
+			return e
+		}
+		monthly, e := fileLoanIDs(ctx, fileList[k].Monthly, monthlyIDField)
+		if e != nil {
+			return e
+		}
+		inTable, e := joined.LoanIDs(*table, k, standard, con)
+		if e != nil {
+			return e
+		}
+		for stage, ids := range dropOuts(static, monthly, inTable) {
+			for _, id := range ids {
+				fmt.Printf("%s,%s,%s,%s\n", k, standard, stage, id)
+			}
+		}
+	}
+	return nil
+}
+
+// the stages at which a loan can fall out of the table
+const (
+	staticOnly    = "static-only"    // the loan is in the static file but not the monthly file
+	monthlyOnly   = "monthly-only"   // the loan is in the monthly file but not the static file
+	failedParsing = "failed-parsing" // the loan is in both files but didn't make it into the table
+)
+
+// dropOuts returns the lnIds that are not in the table, sorted, keyed by the stage at which they fall out.  static
+// and monthly are the lnIds in the static and monthly files.  inTable are the lnIds in the table.
+func dropOuts(static map[string]bool, monthly map[string]bool, inTable map[string]bool) map[string][]string {
+	out := make(map[string][]string)
+	for id := range static {
+		switch {
+		case !monthly[id]:
+			out[staticOnly] = append(out[staticOnly], id)
+		case !inTable[id]:
+			out[failedParsing] = append(out[failedParsing], id)
+		}
+	}
+	for id := range monthly {
+		if !static[id] {
+			out[monthlyOnly] = append(out[monthlyOnly], id)
+		}
+	}
+	for _, ids := range out {
+		sort.Strings(ids)
+	}
+	return out
+}
+
+// the 0-based positions of lnId in the static and monthly files
+const (
+	staticIDField  = 19
+	monthlyIDField = 0
+)
+
+// fileLoanIDs returns the lnIds in the source file name.  The lnId is field ind of each line.
+func fileLoanIDs(ctx context.Context, name string, ind int) (map[string]bool, error) {
+	rs, err := source.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rs.Close() }()
+
+	ids := make(map[string]bool)
+	sc := bufio.NewScanner(rs)
+	sc.Buffer(make([]byte, 1<<20), 1<<20)
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), "|", ind+2)
+		if len(fields) > ind {
+			ids[fields[ind]] = true
+		}
+	}
+	return ids, sc.Err()
+}
+
+// readPublished reads the CSV of Freddie's published figures.  The file has a header row with the columns quarter
+// (CCYYQn), loans and, optionally, standard (Y/N, default Y) and upb.  The totals are keyed by quarter and standard.
+func readPublished(name string) (map[string]map[string]*joined.Totals, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	rdr := csv.NewReader(f)
+	rdr.TrimLeadingSpace = true
+	header, err := rdr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	cols := make(map[string]int)
+	for ind, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = ind
+	}
+	for _, c := range []string{"quarter", "loans"} {
+		if _, ok := cols[c]; !ok {
+			return nil, fmt.Errorf("%s: no %s column", name, c)
+		}
+	}
+
+	pub := make(map[string]map[string]*joined.Totals)
+	for line := 2; ; line++ {
+		rec, e := rdr.Read()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, fmt.Errorf("%s: %v", name, e)
+		}
+		quarter := rec[cols["quarter"]]
+		if !pipeline.QuarterRe.MatchString(quarter) || len(quarter) != 6 {
+			return nil, fmt.Errorf("%s line %d: bad quarter %s", name, line, quarter)
+		}
+		standard := "Y"
+		if ind, ok := cols["standard"]; ok {
+			standard = strings.ToUpper(rec[ind])
+		}
+		t := &joined.Totals{}
+		if t.Loans, e = strconv.ParseInt(rec[cols["loans"]], 10, 64); e != nil {
+			return nil, fmt.Errorf("%s line %d: %v", name, line, e)
+		}
+		if ind, ok := cols["upb"]; ok {
+			if t.UPB, e = strconv.ParseFloat(rec[ind], 64); e != nil {
+				return nil, fmt.Errorf("%s line %d: %v", name, line, e)
+			}
+		}
+		if pub[quarter] == nil {
+			pub[quarter] = make(map[string]*joined.Totals)
+		}
+		pub[quarter][standard] = t
+	}
+	return pub, nil
+}
+
+// writeTotals writes the published and loaded totals of each quarter in pub and their differences to w
+func writeTotals(w io.Writer, pub map[string]map[string]*joined.Totals, loaded map[string]map[string]*joined.Totals) error {
+	keys := make([]string, 0, len(pub))
+	for k := range pub {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "quarter\tstandard\tpublished loans\tloans\tmissing loans\tpublished upb\tupb\tmissing upb\t")
+	var missing int64
+	for _, k := range keys {
+		for _, standard := range []string{"Y", "N"} {
+			p, ok := pub[k][standard]
+			if !ok {
+				continue
+			}
+			l := &joined.Totals{}
+			if x, ok := loaded[k][standard]; ok {
+				l = x
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%0.0f\t%0.0f\t%0.0f\t\n", k, standard, p.Loans, l.Loans,
+				p.Loans-l.Loans, p.UPB, l.UPB, p.UPB-l.UPB)
+			missing += p.Loans - l.Loans
+		}
+	}
+	if e := tw.Flush(); e != nil {
+		return e
+	}
+	_, err := fmt.Fprintf(w, "total missing loans: %d\n", missing)
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDropOuts(t *testing.T) {
+	static := map[string]bool{"F10Q10000001": true, "F10Q10000002": true, "F10Q10000003": true}
+	monthly := map[string]bool{"F10Q10000001": true, "F10Q10000003": true, "F10Q10000004": true}
+	inTable := map[string]bool{"F10Q10000001": true}
+	want := map[string][]string{
+		staticOnly:    {"F10Q10000002"},
+		monthlyOnly:   {"F10Q10000004"},
+		failedParsing: {"F10Q10000003"},
+	}
+	if got := dropOuts(static, monthly, inTable); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestReadPublished(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "published.csv")
+	body := "Quarter,Standard,Loans,UPB\n2010Q1,Y,100,2.5e7\n2010Q1,N,3,600000\n"
+	if e := os.WriteFile(name, []byte(body), 0600); e != nil {
+		t.Fatal(e)
+	}
+	pub, err := readPublished(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub["2010Q1"]["Y"].Loans != 100 || pub["2010Q1"]["N"].UPB != 600000 {
+		t.Errorf("got %+v %+v", pub["2010Q1"]["Y"], pub["2010Q1"]["N"])
+	}
+}
@@ -0,0 +1,85 @@
+// Package runs records the lineage of each load in a ClickHouse table.  There is one row for each run of the load
+// command.  The row records the run id, the target table, the flags, the version of the loader, the as-of date and
+// spec version of the validation and the time taken by each quarter.  The run id is also stored on every loan the
+// run loads.
+package runs
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/invertedv/chutils"
+	"time"
+)
+
+// Status values for a run
+const (
+	Started = "started" // Started means the run began but has not finished
+	Done    = "done"    // Done means all the quarters of the run loaded
+	Failed  = "failed"  // Failed means the run stopped with an error
+)
+
+// Run is the row for a single run.
+type Run struct {
+	RunID       string             // RunID is the unique id of the run
+	Target      string             // Target is the table the run loads
+	Version     string             // Version is the version of the loader
+	CHVersion   string             // CHVersion is the version of the ClickHouse server
+	Flags       map[string]string  // Flags are the values of the flags, except the password
+	Quarters    map[string]float64 // Quarters are the minutes taken by each quarter loaded
+	AsOf        time.Time          // AsOf is the as-of date that bounds the dates when the files are validated
+	SpecVersion string             // SpecVersion is the version of the validation spec
+	CBSAVintage string             // CBSAVintage is the vintage of the CBSA delineation of msaD
+	Status      string             // Status is one of Started, Done, Failed
+	Error       string             // Error is the error that stopped the run, if it failed
+	Started     time.Time          // Started is the time the run began
+	Finished    time.Time          // Finished is the time the run ended (successfully or not)
+}
+
+// NewRun returns a Run with a new run id that starts now.
+func NewRun(target string, version string, flags map[string]string) *Run {
+	return &Run{RunID: uuid.NewString(), Target: target, Version: version, Flags: flags,
+		Quarters: make(map[string]float64), Status: Started, Started: time.Now()}
+}
+
+// Create creates the runs table, if it does not exist.  The engine is ReplacingMergeTree so that the most recent
+// row for a run is the one that survives.
+func Create(table string, con *chutils.Connect) error {
+	qry := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    runId String,
+    target String,
+    version String,
+    chVersion String,
+    flags Map(String, String),
+    quarters Map(String, Float64),
+    status LowCardinality(String),
+    error String,
+    started DateTime,
+    finished DateTime,
+    asOf Date,
+    specVersion String,
+    cbsaVintage String,
+    updated DateTime64(3)
+) ENGINE=ReplacingMergeTree(updated)
+ORDER BY runId`, table)
+	_, err := con.Exec(qry)
+	return err
+}
+
+// columns are the columns of the runs table, other than updated
+const columns = `runId, target, version, chVersion, flags, quarters, status, error, started, finished, asOf, specVersion, cbsaVintage`
+
+// Write adds r to the runs table.  It replaces any earlier row for the same run.  CHVersion is filled in from the
+// server, if it is empty.
+func Write(table string, r *Run, con *chutils.Connect) error {
+	if r.CHVersion == "" {
+		if e := con.QueryRow("SELECT version()").Scan(&r.CHVersion); e != nil {
+			return e
+		}
+	}
+	qry := fmt.Sprintf("INSERT INTO %s (%s, updated) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, now64(3))",
+		table, columns)
+	_, err := con.Exec(qry, r.RunID, r.Target, r.Version, r.CHVersion, r.Flags, r.Quarters, r.Status, r.Error,
+		r.Started, r.Finished, r.AsOf, r.SpecVersion, r.CBSAVintage)
+	return err
+}
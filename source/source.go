@@ -0,0 +1,379 @@
+// Package source opens the Freddie Mac source files for reading.  A source is either a plain text file or a file
+// within a zip archive.  A file within an archive is named as if the archive were a directory, e.g.
+//
+//	/data/historical_data_2010Q1.zip/historical_data_time_2010Q1.txt
+//
+// Files may also be compressed with gzip or zstd.  Compression is detected by the extension (.gz, .zst) or the
+// magic bytes at the start of the file.
+//
+// Files within an archive and compressed files are read as a stream -- they are never unzipped to disk.  Since the
+// chutils file reader needs an io.ReadSeekCloser, a stream supports seeking to the start of the file, which it does
+// by re-opening it.  Other seeks are not supported, so a stream cannot be split with file.Rdrs.  Fan provides a set
+// of readers that divide a stream among them.
+//
+// Once the context passed to Open or Fan is cancelled, reads return the context's error.
+package source
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// compression types
+const (
+	plain = 0 + iota
+	gzipped
+	zstded
+)
+
+// compression returns the compression type of the file name which starts with the bytes head
+func compression(name string, head []byte) int {
+	switch {
+	case strings.HasSuffix(name, ".gz") || strings.HasPrefix(string(head), "\x1f\x8b"):
+		return gzipped
+	case strings.HasSuffix(name, ".zst") || strings.HasPrefix(string(head), "\x28\xb5\x2f\xfd"):
+		return zstded
+	}
+	return plain
+}
+
+// opener opens a source at its start
+type opener func() (io.ReadCloser, error)
+
+// decompress returns an opener that decompresses the output of open, if needed.  name is the file name.
+func decompress(name string, open opener) opener {
+	return func() (io.ReadCloser, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		br := bufio.NewReader(rc)
+		// an error here means the file is shorter than 4 bytes, so treat it as plain
+		head, _ := br.Peek(4)
+		switch compression(name, head) {
+		case gzipped:
+			zr, e := gzip.NewReader(br)
+			if e != nil {
+				_ = rc.Close()
+				return nil, e
+			}
+			return &readCloser{Reader: zr, closers: []io.Closer{zr, rc}}, nil
+		case zstded:
+			zr, e := zstd.NewReader(br)
+			if e != nil {
+				_ = rc.Close()
+				return nil, e
+			}
+			return &readCloser{Reader: zr, closers: []io.Closer{zr.IOReadCloser(), rc}}, nil
+		}
+		return &readCloser{Reader: br, closers: []io.Closer{rc}}, nil
+	}
+}
+
+// readCloser is a Reader with Closers that need to be closed when it is.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes the closers, returning the first error.
+func (r *readCloser) Close() (err error) {
+	for _, c := range r.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Split splits name into the archive and the file within the archive.  If name is not within an archive,
+// archive is empty and entry is name.
+func Split(name string) (archive string, entry string) {
+	if ind := strings.Index(name, ".zip/"); ind > 0 {
+		return name[:ind+4], name[ind+5:]
+	}
+	return "", name
+}
+
+// Open opens name for reading.  An uncompressed file that is not in an archive supports all seeks.
+func Open(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	archive, entry := Split(name)
+	if archive == "" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		head := make([]byte, 4)
+		n, _ := f.ReadAt(head, 0)
+		if compression(name, head[:n]) == plain {
+			return &file{File: f, ctx: ctx}, nil
+		}
+		_ = f.Close()
+		s, e := newStream(ctx, decompress(name, func() (io.ReadCloser, error) { return os.Open(name) }), nil)
+		if e != nil {
+			return nil, e
+		}
+		return s, nil
+	}
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name == entry {
+			s, e := newStream(ctx, decompress(entry, f.Open), zr)
+			if e != nil {
+				_ = zr.Close()
+				return nil, e
+			}
+			return s, nil
+		}
+	}
+	_ = zr.Close()
+	return nil, fmt.Errorf("%s not found in archive %s", entry, archive)
+}
+
+// Size returns the # of bytes name takes on disk.  For a file within an archive, this is its compressed size.
+func Size(name string) (int64, error) {
+	archive, entry := Split(name)
+	if archive == "" {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = zr.Close() }()
+	for _, f := range zr.File {
+		if f.Name == entry {
+			return int64(f.CompressedSize64), nil
+		}
+	}
+	return 0, fmt.Errorf("%s not found in archive %s", entry, archive)
+}
+
+// Seekable returns true if rs, which was returned by Open, supports arbitrary seeks.  Streams do not.
+func Seekable(rs io.ReadSeekCloser) bool {
+	_, ok := rs.(*file)
+	return ok
+}
+
+// CountLines returns the number of lines in name.  A last line without a trailing newline is counted.
+func CountLines(ctx context.Context, name string) (int64, error) {
+	info, err := Fingerprint(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	return info.Lines, nil
+}
+
+// Info describes the content of a source
+type Info struct {
+	SHA256 string // SHA256 is the hex SHA-256 of the content
+	Size   int64  // Size is the # of bytes of content
+	Lines  int64  // Lines is the # of lines of content.  A last line without a trailing newline is counted.
+}
+
+// Fingerprint reads name and returns its Info.  The content is what Open reads -- that is, after decompression --
+// so a file has the same fingerprint whether it is compressed or in an archive.
+func Fingerprint(ctx context.Context, name string) (*Info, error) {
+	rs, err := Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rs.Close() }()
+
+	info := &Info{}
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	last := byte('\n')
+	for {
+		nr, e := rs.Read(buf)
+		if nr > 0 {
+			_, _ = h.Write(buf[:nr])
+			info.Size += int64(nr)
+			info.Lines += int64(bytes.Count(buf[:nr], []byte{'\n'}))
+			last = buf[nr-1]
+		}
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, e
+		}
+	}
+	if last != '\n' {
+		info.Lines++
+	}
+	info.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return info, nil
+}
+
+// file is an uncompressed file
+type file struct {
+	*os.File
+	ctx context.Context
+}
+
+// Read reads from the file
+func (f *file) Read(p []byte) (int, error) {
+	if e := f.ctx.Err(); e != nil {
+		return 0, e
+	}
+	return f.File.Read(p)
+}
+
+// Entries returns the names of the files in archive.  The names include the archive path (see Split).
+func Entries(archive string) ([]string, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	names := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		names = append(names, filepath.Join(archive, f.Name))
+	}
+	return names, nil
+}
+
+// stream implements io.ReadSeekCloser for sources that can only be read from start to end.
+type stream struct {
+	open   opener          // open (re-)opens the source at the start
+	rc     io.ReadCloser   // rc is the open source
+	closer io.Closer       // closer is closed along with rc (e.g. the archive)
+	ctx    context.Context // ctx stops reads once it is cancelled
+}
+
+// newStream creates a stream and opens it
+func newStream(ctx context.Context, open opener, closer io.Closer) (*stream, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &stream{open: open, rc: rc, closer: closer, ctx: ctx}, nil
+}
+
+// Read reads from the source
+func (s *stream) Read(p []byte) (int, error) {
+	if e := s.ctx.Err(); e != nil {
+		return 0, e
+	}
+	return s.rc.Read(p)
+}
+
+// Seek moves to the start of the source.  Any other seek returns an error.
+func (s *stream) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, fmt.Errorf("source stream can only seek to the start")
+	}
+	if e := s.rc.Close(); e != nil {
+		return 0, e
+	}
+	var err error
+	s.rc, err = s.open()
+	return 0, err
+}
+
+// Close closes the source
+func (s *stream) Close() error {
+	err := s.rc.Close()
+	if s.closer != nil {
+		if e := s.closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// fanLines is the number of lines Fan hands to a reader at a time
+const fanLines = 10000
+
+// Fan opens name and divides its lines among n readers.  The lines are dealt to the readers in blocks of fanLines,
+// in turn, by a single goroutine.  Therefore, the source is read once no matter how many readers there are.
+//
+// The readers must be read concurrently, since the goroutine blocks until the reader whose turn it is accepts its
+// block.  Closing any reader stops the goroutine and the other readers return an error.  The readers do not support
+// Seek.
+func Fan(ctx context.Context, name string, n int) ([]io.ReadSeekCloser, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("must have >= 1 reader")
+	}
+	src, err := Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rdrs := make([]io.ReadSeekCloser, n)
+	wrtrs := make([]*io.PipeWriter, n)
+	for ind := 0; ind < n; ind++ {
+		pr, pw := io.Pipe()
+		rdrs[ind], wrtrs[ind] = &pipe{pr}, pw
+	}
+
+	go func() {
+		// done closes the writers. If err is nil, the readers get io.EOF
+		done := func(err error) {
+			_ = src.Close()
+			for _, w := range wrtrs {
+				_ = w.CloseWithError(err)
+			}
+		}
+		br := bufio.NewReaderSize(src, 1<<20)
+		buf := make([]byte, 0)
+		for ind := 0; ; ind = (ind + 1) % n {
+			buf = buf[:0]
+			var e error
+			for l := 0; l < fanLines && e == nil; l++ {
+				var line []byte
+				line, e = br.ReadBytes('\n')
+				buf = append(buf, line...)
+			}
+			if e != nil && e != io.EOF {
+				done(e)
+				return
+			}
+			if len(buf) > 0 {
+				if _, ew := wrtrs[ind].Write(buf); ew != nil {
+					done(ew)
+					return
+				}
+			}
+			if e == io.EOF {
+				done(nil)
+				return
+			}
+		}
+	}()
+	return rdrs, nil
+}
+
+// pipe is a reader returned by Fan
+type pipe struct {
+	*io.PipeReader
+}
+
+// Seek returns an error -- a pipe cannot seek
+func (p *pipe) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("cannot seek a reader returned by Fan")
+}
@@ -0,0 +1,164 @@
+package source
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeZip creates a zip archive in a temp directory holding the files in contents
+func makeZip(t *testing.T, contents map[string]string) string {
+	archive := filepath.Join(t.TempDir(), "historical_data_2010Q1.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, body := range contents {
+		w, e := zw.Create(name)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if _, e := w.Write([]byte(body)); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+	if e := f.Close(); e != nil {
+		t.Fatal(e)
+	}
+	return archive
+}
+
+func TestOpen(t *testing.T) {
+	body := "F10Q10000001|201001|1000\nF10Q10000001|201002|999\n"
+	archive := makeZip(t, map[string]string{"historical_data_time_2010Q1.txt": body, "historical_data_2010Q1.txt": "x"})
+
+	names, err := Entries(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(names))
+	}
+
+	name := filepath.Join(archive, "historical_data_time_2010Q1.txt")
+	if a, e := Split(name); a != archive || e != "historical_data_time_2010Q1.txt" {
+		t.Fatalf("bad split of %s: %s %s", name, a, e)
+	}
+	rs, err := Open(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rs.Close() }()
+
+	// read it twice to check that Seek goes back to the start
+	for pass := 0; pass < 2; pass++ {
+		b, e := io.ReadAll(rs)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if string(b) != body {
+			t.Fatalf("pass %d: got %q", pass, string(b))
+		}
+		if _, e := rs.Seek(0, io.SeekStart); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if _, e := rs.Seek(10, io.SeekStart); e == nil {
+		t.Fatal("expected error seeking past start")
+	}
+
+	// the fingerprint of the entry is that of its content
+	info, err := Fingerprint(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(body))
+	if info.SHA256 != hex.EncodeToString(sum[:]) || info.Size != int64(len(body)) || info.Lines != 2 {
+		t.Fatalf("bad fingerprint %+v", info)
+	}
+
+	if _, e := Open(context.Background(), filepath.Join(archive, "nothere.txt")); e == nil {
+		t.Fatal("expected error for missing entry")
+	}
+
+	// reads stop once the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := Open(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rc.Close() }()
+	cancel()
+	if _, e := io.ReadAll(rc); e != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", e)
+	}
+}
+
+func TestFan(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "historical_data_time_2010Q1.txt.gz")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	nLines := 3*fanLines + 17
+	for ind := 0; ind < nLines; ind++ {
+		if _, e := fmt.Fprintf(zw, "F10Q1%07d|201001|1000\n", ind); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+	if e := f.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	rs, err := Open(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Seekable(rs) {
+		t.Fatal("compressed file should not be seekable")
+	}
+	_ = rs.Close()
+
+	if n, e := CountLines(context.Background(), name); e != nil || n != int64(nLines) {
+		t.Fatalf("CountLines: expected %d lines, got %d (%v)", nLines, n, e)
+	}
+
+	rdrs, err := Fan(context.Background(), name, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(chan int)
+	for _, r := range rdrs {
+		go func(r io.ReadSeekCloser) {
+			b, e := io.ReadAll(r)
+			if e != nil {
+				t.Error(e)
+			}
+			counts <- strings.Count(string(b), "\n")
+		}(r)
+	}
+	total := 0
+	for range rdrs {
+		total += <-counts
+	}
+	if total != nLines {
+		t.Fatalf("expected %d lines, got %d", nLines, total)
+	}
+}
@@ -0,0 +1,174 @@
+// Package spec holds the validation spec of the static and monthly fields: the legal range or levels of each
+// field, its missing value and its default.  The built-in spec is the one in the static and monthly packages.  A
+// spec file, YAML or JSON, overrides the fields it lists, e.g.
+//
+//	version: risk-2024-03
+//	static:
+//	  fico: {min: 300, max: 850}
+//	  dti: {max: 50}
+//	monthly:
+//	  upb: {max: 3000000}
+//	  curRate: {max: 12.5}
+//
+// Every spec file has a version, which is recorded with the load.  Dates are given as CCYY-MM-DD.  The upper bound
+// of the dates comes from the as-of date of the load, unless the spec sets it.
+package spec
+
+import (
+	"fmt"
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/freddie/cbsa"
+	"github.com/invertedv/freddie/zip3"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Builtin is the version of the built-in spec
+const Builtin = "builtin-1"
+
+// Field is the spec of a field.  Fields that are nil (or empty, for Levels) are left as they are.
+type Field struct {
+	Min     interface{} `yaml:"min,omitempty" json:"min,omitempty"`         // Min is the lowest legal value
+	Max     interface{} `yaml:"max,omitempty" json:"max,omitempty"`         // Max is the highest legal value
+	Levels  []string    `yaml:"levels,omitempty" json:"levels,omitempty"`   // Levels are the legal values of a string
+	Missing interface{} `yaml:"missing,omitempty" json:"missing,omitempty"` // Missing is the value of a field that fails
+	Default interface{} `yaml:"default,omitempty" json:"default,omitempty"` // Default is the value of an empty field
+}
+
+// Spec is a versioned validation spec.  The fields are keyed by name.
+type Spec struct {
+	Version string            `yaml:"version" json:"version"`
+	Static  map[string]*Field `yaml:"static,omitempty" json:"static,omitempty"`
+	Monthly map[string]*Field `yaml:"monthly,omitempty" json:"monthly,omitempty"`
+}
+
+// Settings are the settings of the validation of a load
+type Settings struct {
+	AsOf time.Time         // AsOf is the latest legal date
+	Spec *Spec             // Spec overrides the built-in spec, if not nil
+	CBSA *cbsa.Delineation // CBSA has the legal msaD codes and their names, if not nil
+	Zip3 *zip3.Crosswalk   // Zip3 has the states of each zip3, if not nil
+}
+
+// Version returns the version of the spec of s
+func (s *Settings) Version() string {
+	if s == nil || s.Spec == nil {
+		return Builtin
+	}
+	return s.Spec.Version
+}
+
+// CBSAVintage returns the vintage of the CBSA delineation of s
+func (s *Settings) CBSAVintage() string {
+	if s == nil || s.CBSA == nil {
+		return cbsa.Builtin
+	}
+	return s.CBSA.Vintage
+}
+
+// Read reads the spec file name.  JSON is read as YAML, of which it is a subset.
+func Read(name string) (*Spec, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	sp := &Spec{}
+	if e := yaml.Unmarshal(b, sp); e != nil {
+		return nil, fmt.Errorf("spec file %s: %v", name, e)
+	}
+	if sp.Version == "" {
+		return nil, fmt.Errorf("spec file %s: no version", name)
+	}
+	return sp, nil
+}
+
+// Apply sets the fields of td to their specs in fields.  It is an error for fields to have a field td doesn't.
+func Apply(td *chutils.TableDef, fields map[string]*Field) error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := fields[name]
+		_, fd, err := td.Get(name)
+		if err != nil {
+			return fmt.Errorf("spec: unknown field %s", name)
+		}
+		if f == nil {
+			continue
+		}
+		if fd.Legal == nil {
+			fd.Legal = chutils.NewLegalValues()
+		}
+		vals := []struct {
+			from interface{}
+			to   *interface{}
+		}{{f.Min, &fd.Legal.LowLimit}, {f.Max, &fd.Legal.HighLimit}, {f.Missing, &fd.Missing}, {f.Default, &fd.Default}}
+		for _, v := range vals {
+			if v.from == nil {
+				continue
+			}
+			x, e := value(fd, v.from)
+			if e != nil {
+				return e
+			}
+			*v.to = x
+		}
+		if len(f.Levels) > 0 {
+			fd.Legal.Levels = append([]string{}, f.Levels...)
+		}
+	}
+	return nil
+}
+
+// value returns v as a value of field fd.  Dates are CCYY-MM-DD; other types are converted by TableDef.Check.
+func value(fd *chutils.FieldDef, v interface{}) (interface{}, error) {
+	if fd.ChSpec.Base != chutils.ChDate {
+		return v, nil
+	}
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		dt, err := time.Parse("2006-01-02", x)
+		if err != nil {
+			return nil, fmt.Errorf("spec: field %s: bad date %s, need CCYY-MM-DD", fd.Name, x)
+		}
+		return dt, nil
+	}
+	return nil, fmt.Errorf("spec: field %s: bad date %v, need CCYY-MM-DD", fd.Name, v)
+}
+
+// FromTableDef returns the specs of the fields of td.  The upper bound of the dates is left out, since it comes
+// from the as-of date.
+func FromTableDef(td *chutils.TableDef) map[string]*Field {
+	fields := make(map[string]*Field)
+	for _, fd := range td.FieldDefs {
+		f := &Field{Missing: export(fd.Missing), Default: export(fd.Default)}
+		if fd.Legal != nil {
+			f.Min, f.Levels = export(fd.Legal.LowLimit), fd.Legal.Levels
+			if fd.ChSpec.Base != chutils.ChDate {
+				f.Max = export(fd.Legal.HighLimit)
+			}
+		}
+		fields[fd.Name] = f
+	}
+	return fields
+}
+
+// export returns v as it is written to a spec file
+func export(v interface{}) interface{} {
+	switch x := v.(type) {
+	case time.Time:
+		return x.Format("2006-01-02")
+	case string:
+		if strings.TrimSpace(x) == "" {
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,55 @@
+package spec
+
+import (
+	"github.com/invertedv/chutils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "spec.yaml")
+	body := "version: test-1\nstatic:\n  fico: {min: 620, max: 800}\n  occ: {levels: [P, S]}\n  fpDt: {max: 2020-12-01}\n"
+	if e := os.WriteFile(name, []byte(body), 0600); e != nil {
+		t.Fatal(e)
+	}
+	sp, err := Read(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := (&Settings{Spec: sp}).Version(); v != "test-1" {
+		t.Errorf("version: got %s, want test-1", v)
+	}
+
+	fds := map[int]*chutils.FieldDef{
+		0: {Name: "fico", ChSpec: chutils.ChField{Base: chutils.ChInt, Length: 32},
+			Legal: &chutils.LegalValues{LowLimit: int32(301), HighLimit: int32(850)}, Missing: int32(-1)},
+		1: {Name: "occ", ChSpec: chutils.ChField{Base: chutils.ChFixedString, Length: 1},
+			Legal: &chutils.LegalValues{Levels: []string{"P", "S", "I"}}, Missing: "X"},
+		2: {Name: "fpDt", ChSpec: chutils.ChField{Base: chutils.ChDate, Format: "200601"},
+			Legal:   &chutils.LegalValues{LowLimit: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC), HighLimit: time.Now()},
+			Missing: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	td := chutils.NewTableDef("fico", chutils.MergeTree, fds)
+	if e := Apply(td, sp.Static); e != nil {
+		t.Fatal(e)
+	}
+	if e := td.Check(); e != nil {
+		t.Fatal(e)
+	}
+	if fd := td.FieldDefs[0]; fd.Legal.LowLimit != int32(620) || fd.Legal.HighLimit != int32(800) {
+		t.Errorf("fico: got %v-%v", fd.Legal.LowLimit, fd.Legal.HighLimit)
+	}
+	if fd := td.FieldDefs[1]; !reflect.DeepEqual(fd.Legal.Levels, []string{"P", "S"}) {
+		t.Errorf("occ: got %v", fd.Legal.Levels)
+	}
+	if fd := td.FieldDefs[2]; !fd.Legal.HighLimit.(time.Time).Equal(time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("fpDt: got %v", fd.Legal.HighLimit)
+	}
+
+	if e := Apply(td, map[string]*Field{"notAField": {}}); e == nil {
+		t.Error("expected error for unknown field")
+	}
+}
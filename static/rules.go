@@ -0,0 +1,107 @@
+package static
+
+import (
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/freddie/spec"
+	"github.com/invertedv/freddie/zip3"
+	"strings"
+	"time"
+)
+
+// rule is a cross-field check of a row.  A row that fails has the name of the rule in qaStatic, along with the
+// names of the fields that failed validation.
+type rule struct {
+	name   string   // name is the name in qaStatic
+	fields []string // fields must all pass validation (or take their default) for the rule to be checked
+	// ok returns false if the row fails the rule.  get returns the value of a field.
+	ok func(get func(field string) interface{}) bool
+}
+
+// rules are the cross-field checks of the static data.  There is no io/amType rule: Freddie has io loans of both
+// amortization types, and the levels of io and amType are checked by their fields.
+var rules = []rule{
+	{
+		// the maturity date is the last payment date of the loan's term
+		name:   "matDtTerm",
+		fields: []string{"fpDt", "matDt", "term"},
+		ok: func(get func(string) interface{}) bool {
+			mat := get("fpDt").(time.Time).AddDate(0, int(get("term").(int32))-1, 0)
+			matDt := get("matDt").(time.Time)
+			return mat.Year() == matDt.Year() && mat.Month() == matDt.Month()
+		},
+	},
+	{
+		name:   "cltvLtv",
+		fields: []string{"cltv", "ltv"},
+		ok: func(get func(string) interface{}) bool {
+			return get("cltv").(int32) >= get("ltv").(int32)
+		},
+	},
+	{
+		// a HARP loan refinances a Freddie loan, which is preHarpLnId
+		name:   "harpPreHarpLnId",
+		fields: []string{"harp"},
+		ok: func(get func(string) interface{}) bool {
+			pre, _ := get("preHarpLnId").(string)
+			return get("harp").(string) != "Y" || strings.TrimSpace(pre) != ""
+		},
+	},
+	{
+		// mi is rarely needed below 80 LTV, so this flags loans to look at rather than errors
+		name:   "miLtv",
+		fields: []string{"mi", "ltv"},
+		ok: func(get func(string) interface{}) bool {
+			return get("mi").(int32) == 0 || get("ltv").(int32) >= 80
+		},
+	},
+}
+
+// ruleSet returns the rules to check with the settings set: rules plus, if set has a zip3 crosswalk, zipState.
+func ruleSet(set *spec.Settings) []rule {
+	rs := append([]rule{}, rules...)
+	if set.Zip3 != nil {
+		rs = append(rs, zipStateRule(set.Zip3))
+	}
+	return rs
+}
+
+// zipStateRule returns the rule that state is one of the states of the zip3 of zip in xw.  Zip3s that are not in
+// xw, including zips too short to have one, are not checked.
+func zipStateRule(xw *zip3.Crosswalk) rule {
+	return rule{
+		name:   "zipState",
+		fields: []string{"zip", "state"},
+		ok: func(get func(string) interface{}) bool {
+			zip := get("zip").(string)
+			return xw.Majority(zip) == "" || xw.Has(zip, get("state").(string))
+		},
+	}
+}
+
+// checkRules returns the names of the rules in rs the row fails
+func checkRules(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, rs []rule) ([]string, error) {
+	// get returns the value of a field, nil if it failed validation
+	get := func(field string) interface{} {
+		ind, _, err := td.Get(field)
+		if err != nil || (valid[ind] != chutils.VPass && valid[ind] != chutils.VDefault) {
+			return nil
+		}
+		return data[ind]
+	}
+	fails := make([]string, 0)
+	for _, r := range rs {
+		checked := true
+		for _, f := range r.fields {
+			if _, _, e := td.Get(f); e != nil {
+				return nil, e
+			}
+			if get(f) == nil {
+				checked = false
+			}
+		}
+		if checked && !r.ok(get) {
+			fails = append(fails, r.name)
+		}
+	}
+	return fails, nil
+}
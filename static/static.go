@@ -2,31 +2,51 @@
 package static
 
 import (
+	"context"
 	"fmt"
 	"github.com/invertedv/chutils"
 	"github.com/invertedv/chutils/file"
 	"github.com/invertedv/chutils/nested"
 	s "github.com/invertedv/chutils/sql"
-	"os"
+	"github.com/invertedv/freddie/cbsa"
+	"github.com/invertedv/freddie/metrics"
+	"github.com/invertedv/freddie/qa"
+	"github.com/invertedv/freddie/source"
+	"github.com/invertedv/freddie/spec"
+	"github.com/invertedv/freddie/zip3"
 	"time"
 )
 
 // TableDef is TableDef for the static table.  It is exported as other packages (e.g. joined) may need fields from
 // it (e.g. Description)
-var TableDef *chutils.TableDef
+var TableDef = tableDef()
 
-// LoadRaw loads the static data from file sourceFile into "table".  The table is created/reset if create=true. con
-// is the connector to ClickHouse.
-func LoadRaw(sourceFile string, table string, create bool, con *chutils.Connect) (err error) {
-	fileName = sourceFile // fileName is global to the package so we have it to add as a field
+// tableDef returns the TableDef of the fields in the source file plus the fields LoadRaw adds.  It is used for the
+// field descriptions, so the as-of date of the date bounds doesn't matter.
+func tableDef() *chutils.TableDef {
+	td := build(time.Time{})
+	for _, fd := range xtraFields() {
+		td.FieldDefs[len(td.FieldDefs)] = fd
+	}
+	return td
+}
 
-	// build initial reader
-	f, err := os.Open(fileName)
+// Spec returns the built-in spec of the fields of the source file
+func Spec() map[string]*spec.Field {
+	return spec.FromTableDef(build(time.Time{}))
+}
+
+// LoadRaw loads the static data from file sourceFile into "table".  The table is created/reset if create=true. con
+// is the connector to ClickHouse.  set holds the as-of date, the latest legal date (e.g. of fpDt), the spec
+// that overrides the validation of build, the CBSA delineation, if any, that gives the legal msaD codes and the
+// msaName, cbsa and cbsaName fields, and the zip3 crosswalk, if any, that checks state and gives zip3State.
+// sourceFile may be compressed and/or within a zip archive (see package source).  If ctx is cancelled, reading
+// stops and ctx.Err() is returned.
+func LoadRaw(ctx context.Context, sourceFile string, table string, create bool, set *spec.Settings, con *chutils.Connect) (err error) {
+	nrdr, rdr, err := reader(ctx, sourceFile, set)
 	if err != nil {
 		return err
 	}
-	rdr := file.NewReader(fileName, '|', '\n', '"', 0, 0, 0, f, 6000000)
-	rdr.Skip = 0
 	defer func() {
 		// don't throw an error if we already have one
 		if e := rdr.Close(); e != nil && err == nil {
@@ -34,37 +54,101 @@ func LoadRaw(sourceFile string, table string, create bool, con *chutils.Connect)
 		}
 	}()
 
-	rdr.SetTableSpec(build())
-	if e := rdr.TableSpec().Check(); e != nil {
-		return e
-	}
-
-	newCalcs := make([]nested.NewCalcFn, 0)
-	newCalcs = append(newCalcs, fField, vintField, pvField, vField)
-
-	// nrdr is a nested reader -- this is needed to add the new fields
-	nrdr, err := nested.NewReader(rdr, xtraFields(), newCalcs)
-	TableDef = nrdr.TableSpec()
-
 	if create {
 		if err = nrdr.TableSpec().Create(con, table); err != nil {
 			return err
 		}
 	}
 
+	ld := metrics.StartLoad("static", sourceFile)
+	defer ld.Done()
 	wrtr := s.NewWriter(table, con)
-	if err = chutils.Export(nrdr, wrtr, 400000, false); err != nil {
+	if err = chutils.Export(ld.Input(nrdr), ld.Output(wrtr), 400000, false); err != nil {
+		// Export doesn't pass along the read error
+		if e := ctx.Err(); e != nil {
+			return e
+		}
 		return
 	}
 	return nil
 }
 
+// DryRun reads and validates sourceFile, including the fields LoadRaw adds, without loading it.  The validation
+// results of each field are returned.  set is as for LoadRaw.
+func DryRun(ctx context.Context, sourceFile string, set *spec.Settings) (prof *qa.Profile, err error) {
+	nrdr, rdr, err := reader(ctx, sourceFile, set)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := rdr.Close(); e != nil && err == nil {
+			prof, err = nil, e
+		}
+	}()
+	return qa.Run(ctx, []chutils.Input{nrdr})
+}
+
+// reader returns the nested reader that reads sourceFile and adds the extra fields.  The file reader it is based on
+// is also returned -- the caller must close it.
+func reader(ctx context.Context, sourceFile string, set *spec.Settings) (*nested.Reader, *file.Reader, error) {
+	// build initial reader
+	f, err := source.Open(ctx, sourceFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	rdr := file.NewReader(sourceFile, '|', '\n', '"', 0, 0, 0, f, 6000000)
+	rdr.Skip = 0
+
+	td := build(set.AsOf)
+	if set.Spec != nil {
+		if e := spec.Apply(td, set.Spec.Static); e != nil {
+			_ = rdr.Close()
+			return nil, nil, e
+		}
+	}
+	// the CBSA levels come after the spec, since a spec that lists the msaD levels replaces them
+	if set.CBSA != nil {
+		_, fd, e := td.Get("msaD")
+		if e != nil {
+			_ = rdr.Close()
+			return nil, nil, e
+		}
+		levels := set.CBSA.Levels()
+		if def, ok := fd.Default.(string); ok {
+			levels = append(levels, def)
+		}
+		fd.Legal.Levels = levels
+	}
+	rdr.SetTableSpec(td)
+	if e := rdr.TableSpec().Check(); e != nil {
+		_ = rdr.Close()
+		return nil, nil, e
+	}
+	// rr validates the rows, so it knows why a field fails
+	rr := qa.NewReasons(rdr)
+
+	newCalcs := make([]nested.NewCalcFn, 0)
+	newCalcs = append(newCalcs, fField(sourceFile), vintField, pvField, vField(ruleSet(set), rr),
+		areaField(set.CBSA, func(a *cbsa.Area) string { return a.Name }),
+		areaField(set.CBSA, func(a *cbsa.Area) string { return a.CBSA }),
+		areaField(set.CBSA, func(a *cbsa.Area) string { return a.CBSAName }),
+		zip3StateField(set.Zip3))
+
+	// nrdr is a nested reader -- this is needed to add the new fields
+	nrdr, err := nested.NewReader(rr, xtraFields(), newCalcs)
+	if err != nil {
+		_ = rdr.Close()
+		return nil, nil, err
+	}
+	return nrdr, rdr, nil
+}
+
 // xtraFields defines additional fields for the nested reader
 func xtraFields() (fds []*chutils.FieldDef) {
 	vfd := &chutils.FieldDef{
 		Name:        "qaStatic",
 		ChSpec:      chutils.ChField{Base: chutils.ChString, Funcs: chutils.OuterFuncs{chutils.OuterLowCardinality}},
-		Description: "validation results for each field",
+		Description: "fields and rules that failed validation, with reason codes: :field=reason:",
 		Legal:       chutils.NewLegalValues(),
 		Missing:     "!",
 	}
@@ -89,24 +173,77 @@ func xtraFields() (fds []*chutils.FieldDef) {
 		Legal:       &chutils.LegalValues{LowLimit: float32(1000.0), HighLimit: float32(5000000.0), Levels: nil},
 		Missing:     float32(-1.0),
 	}
-	fds = []*chutils.FieldDef{ffd, vintfd, pvfd, vfd}
+	mnfd := &chutils.FieldDef{
+		Name:        "msaName",
+		ChSpec:      chutils.ChField{Base: chutils.ChString, Funcs: chutils.OuterFuncs{chutils.OuterLowCardinality}},
+		Description: "name of msa/division (from -cbsa), missing=<empty>",
+		Legal:       chutils.NewLegalValues(),
+		Missing:     "",
+	}
+	cfd := &chutils.FieldDef{
+		Name:        "cbsa",
+		ChSpec:      chutils.ChField{Base: chutils.ChString, Funcs: chutils.OuterFuncs{chutils.OuterLowCardinality}},
+		Description: "cbsa code of msa, the parent of a division (from -cbsa), missing=<empty>",
+		Legal:       chutils.NewLegalValues(),
+		Missing:     "",
+	}
+	cnfd := &chutils.FieldDef{
+		Name:        "cbsaName",
+		ChSpec:      chutils.ChField{Base: chutils.ChString, Funcs: chutils.OuterFuncs{chutils.OuterLowCardinality}},
+		Description: "name of cbsa (from -cbsa), missing=<empty>",
+		Legal:       chutils.NewLegalValues(),
+		Missing:     "",
+	}
+	zsfd := &chutils.FieldDef{
+		Name:        "zip3State",
+		ChSpec:      chutils.ChField{Base: chutils.ChString, Funcs: chutils.OuterFuncs{chutils.OuterLowCardinality}},
+		Description: "majority state of the 3 digit zip (from -zip3), missing=<empty>",
+		Legal:       chutils.NewLegalValues(),
+		Missing:     "",
+	}
+	fds = []*chutils.FieldDef{ffd, vintfd, pvfd, vfd, mnfd, cfd, cnfd, zsfd}
 	return
 }
 
-// vField returns the validation results for each field -- 0 = pass, 1 = fail in a string which has a  keyval format
-func vField(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
-	res := make([]byte, 0)
-	res = append(res, []byte(":")...)
-	for ind, v := range valid {
-		name := td.FieldDefs[ind].Name
-		if v != chutils.VPass && v != chutils.VDefault {
-			res = append(res, []byte(name+":")...)
+// areaField returns a function that returns a field of the area of msaD in d, as chosen by get.  The field is
+// empty if d is nil or msaD is not one of its areas.
+func areaField(d *cbsa.Delineation, get func(a *cbsa.Area) string) nested.NewCalcFn {
+	return func(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
+		ind, _, err := td.Get("msaD")
+		if err != nil {
+			return nil, err
+		}
+		a := d.Get(data[ind].(string))
+		if a == nil {
+			return "", nil
+		}
+		return get(a), nil
+	}
+}
+
+// vField returns a function that returns the validation results in a string which has a keyval format: each
+// field that fails, with its reason code, then each cross-field rule in rs the row fails (see qa.Failures).  rr is
+// the reader of the row, which has its reason codes.
+func vField(rs []rule, rr *qa.Reasons) nested.NewCalcFn {
+	return func(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
+		fails, err := checkRules(td, data, valid, rs)
+		if err != nil {
+			return nil, err
 		}
+		return qa.Failures(td, valid, rr.Next(), fails), nil
 	}
-	if len(res) > 1 {
-		return string(res), nil
+}
+
+// zip3StateField returns a function that returns the majority state of the zip3 of zip in xw.  It is empty if xw
+// is nil or the zip3 is not in it.
+func zip3StateField(xw *zip3.Crosswalk) nested.NewCalcFn {
+	return func(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
+		ind, _, err := td.Get("zip")
+		if err != nil {
+			return nil, err
+		}
+		return xw.Majority(data[ind].(string)), nil
 	}
-	return "", nil
 }
 
 // fField adds the file name data comes from to output table
@@ -121,12 +258,11 @@ func vintField(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, vali
 	return vintage, nil
 }
 
-// fileName is global since used as a closure to fField
-var fileName string
-
-// fField returns the name of the file the data is loaded from
-func fField(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
-	return fileName, nil
+// fField returns a function that returns the name of the file the data is loaded from
+func fField(fileName string) nested.NewCalcFn {
+	return func(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, validate bool) (interface{}, error) {
+		return fileName, nil
+	}
 }
 
 // pvField calculate property value from ltv
@@ -151,13 +287,14 @@ func pvField(td *chutils.TableDef, data chutils.Row, valid chutils.Valid, valida
 	return opb / (ltv / 100.0), nil
 }
 
-// build builds the TableDef for the static field files.
-func build() *chutils.TableDef {
+// build builds the TableDef for the static field files.  asOf is the latest legal date: dates such as fpDt can't
+// be after it, and matDt can't be more than 40 years after it.
+func build(asOf time.Time) *chutils.TableDef {
 	var (
 		// date ranges & missing value
 		minDt  = time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)
-		nowDt  = time.Now()
-		futDt  = time.Now().AddDate(40, 0, 0)
+		nowDt  = asOf
+		futDt  = asOf.AddDate(40, 0, 0)
 		missDt = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
 
 		strMiss = "X" // generic missing value for FixedString(1)
@@ -0,0 +1,173 @@
+package static
+
+import (
+	"context"
+	"github.com/invertedv/freddie/cbsa"
+	"github.com/invertedv/freddie/spec"
+	"github.com/invertedv/freddie/zip3"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRow returns the fields of a static row that passes validation and the rules
+func testRow() []string {
+	return []string{"751", "201003", "N", "204002", "", "000", "1", "P", "080", "035", "000200000", "080", "5.125",
+		"R", "N", "FRM", "CA", "SF", "94500", "F110Q1000001", "P", "360", "02", "Other sellers", "Other servicers",
+		"", "", "9", "N", "9", "N"}
+}
+
+func TestDryRun(t *testing.T) {
+	row := testRow()
+	good := strings.Join(row, "|")
+	row[0], row[19] = "999", "F110Q1000002" // fico out of range
+	bad := strings.Join(row, "|")
+
+	name := filepath.Join(t.TempDir(), "historical_data_2010Q1.txt")
+	if e := os.WriteFile(name, []byte(good+"\n"+bad+"\n"), 0600); e != nil {
+		t.Fatal(e)
+	}
+	prof, err := DryRun(context.Background(), name, &spec.Settings{AsOf: time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prof.Rows != 2 {
+		t.Fatalf("expected 2 rows, got %d", prof.Rows)
+	}
+	if len(prof.Fields) != len(TableDef.FieldDefs) {
+		t.Fatalf("expected %d fields, got %d", len(TableDef.FieldDefs), len(prof.Fields))
+	}
+	checked := 0
+	for ind, name := range prof.Fields {
+		c := prof.Counts[ind]
+		switch name {
+		case "fico":
+			checked++
+			if c.Pass != 1 || c.Fail != 1 {
+				t.Errorf("fico: expected 1 pass and 1 fail, got %+v", c)
+			}
+		case "propVal", "ltv", "opb":
+			checked++
+			if c.Pass != 2 {
+				t.Errorf("%s: expected 2 passes, got %+v", name, c)
+			}
+		}
+	}
+	if checked != 4 {
+		t.Errorf("expected to check 4 fields, checked %d", checked)
+	}
+}
+
+func TestQA(t *testing.T) {
+	row := testRow()
+	cases := []struct {
+		set  map[int]string // set are the fields of row to change
+		want string
+	}{
+		{nil, ""},
+		{map[int]string{3: "204003"}, ":matDtTerm=rule:"},
+		{map[int]string{8: "070"}, ":cltvLtv=rule:"},
+		{map[int]string{28: "Y"}, ":harpPreHarpLnId=rule:"},
+		{map[int]string{26: "F109Q1000001", 28: "Y"}, ""},
+		{map[int]string{5: "025", 11: "075"}, ":miLtv=rule:"},
+		{map[int]string{15: "ARM", 30: "Y"}, ""},
+		{map[int]string{15: "", 30: "Y"}, ":amType=empty:"},
+		{map[int]string{8: "070", 21: ""}, ":term=empty:cltvLtv=rule:"},
+		{map[int]string{16: "NV"}, ":zipState=rule:"},
+		{map[int]string{16: "NV", 18: "89500"}, ""},
+		{map[int]string{0: "999", 7: "Z"}, ":fico=high:occ=level:"},
+		{map[int]string{0: "250", 16: "CAL"}, ":fico=low:state=long:"},
+		{map[int]string{0: "7x1", 1: "2010-3"}, ":fico=parse:fpDt=parse:"},
+	}
+	lines := make([]string, 0, len(cases))
+	for _, c := range cases {
+		r := append([]string{}, row...)
+		for ind, v := range c.set {
+			r[ind] = v
+		}
+		lines = append(lines, strings.Join(r, "|"))
+	}
+	name := filepath.Join(t.TempDir(), "historical_data_2010Q1.txt")
+	if e := os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0600); e != nil {
+		t.Fatal(e)
+	}
+
+	xw := &zip3.Crosswalk{States: map[string]map[string]float64{"945": {"CA": 1}}}
+	nrdr, rdr, err := reader(context.Background(), name, &spec.Settings{AsOf: time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC), Zip3: xw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rdr.Close() }()
+	ind, _, err := nrdr.TableSpec().Get("qaStatic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := nrdr.Read(len(cases), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cases {
+		if got := data[i][ind]; got != c.want {
+			t.Errorf("case %d: got %q, want %q", i, got, c.want)
+		}
+	}
+	if ind, _, err = nrdr.TableSpec().Get("zip3State"); err != nil {
+		t.Fatal(err)
+	}
+	if got := data[0][ind]; got != "CA" {
+		t.Errorf("zip3State: got %q, want CA", got)
+	}
+}
+
+func TestCBSASpec(t *testing.T) {
+	// a spec exported by freddie spec lists the built-in msaD levels, which -cbsa replaces
+	name := filepath.Join(t.TempDir(), "historical_data_2010Q1.txt")
+	if e := os.WriteFile(name, nil, 0600); e != nil {
+		t.Fatal(e)
+	}
+	set := &spec.Settings{
+		AsOf: time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC),
+		Spec: &spec.Spec{Version: "test", Static: map[string]*spec.Field{"msaD": {Levels: []string{"99999"}}}},
+		CBSA: &cbsa.Delineation{Vintage: "2023", Areas: map[string]*cbsa.Area{"12345": {Code: "12345"}}},
+	}
+	nrdr, rdr, err := reader(context.Background(), name, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rdr.Close() }()
+	_, fd, err := nrdr.TableSpec().Get("msaD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := strings.Join(fd.Legal.Levels, ",")
+	if !strings.Contains(levels, "12345") || strings.Contains(levels, "99999") {
+		t.Errorf("msaD levels: got %s, want those of the delineation", levels)
+	}
+}
+
+func TestZipStateRule(t *testing.T) {
+	xw := &zip3.Crosswalk{States: map[string]map[string]float64{"945": {"CA": 1}}}
+	r := zipStateRule(xw)
+	cases := []struct {
+		zip, state string
+		want       bool
+	}{
+		{"94500", "CA", true},
+		{"94500", "NV", false},
+		{"89500", "NV", true}, // not in the crosswalk
+		{"94", "NV", true},    // too short to have a zip3, as a spec may allow
+	}
+	for _, c := range cases {
+		get := func(field string) interface{} {
+			if field == "zip" {
+				return c.zip
+			}
+			return c.state
+		}
+		if got := r.ok(get); got != c.want {
+			t.Errorf("zip %s state %s: got %v, want %v", c.zip, c.state, got, c.want)
+		}
+	}
+}
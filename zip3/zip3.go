@@ -0,0 +1,121 @@
+// Package zip3 reads a crosswalk from 3-digit zip codes to the states and territories they cover.  A zip3 may
+// cross state lines, so each state of a zip3 has a weight, e.g. its # of zip codes or of addresses, and the
+// majority state of the zip3 is the one with the most weight.
+//
+// The crosswalk file is CSV with a header row.  The columns used are found by their headers:
+//
+//	zip3 or zip, state and, optionally, weight.
+//
+// If the file has a zip column rather than zip3, each row is a 5-digit zip code and is counted in the zip3 of its
+// first 3 digits.  Without a weight column, each row has a weight of 1.  State is the postal abbreviation.
+package zip3
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Crosswalk maps each zip3 to its states
+type Crosswalk struct {
+	File   string                        // File is the file the crosswalk was read from
+	States map[string]map[string]float64 // States are the weights of the states of each zip3
+}
+
+// Has returns true if state is one of the states of zip3.  zip may be the 3-digit zip or the 5-digit zip.
+func (c *Crosswalk) Has(zip string, state string) bool {
+	if c == nil || len(zip) < 3 {
+		return false
+	}
+	_, ok := c.States[zip[:3]][state]
+	return ok
+}
+
+// Majority returns the state of zip3 with the most weight, "" if zip3 is not in the crosswalk.  zip may be the
+// 3-digit zip or the 5-digit zip.  Ties go to the first state alphabetically.
+func (c *Crosswalk) Majority(zip string) string {
+	if c == nil || len(zip) < 3 {
+		return ""
+	}
+	maj, most := "", 0.0
+	for state, w := range c.States[zip[:3]] {
+		if maj == "" || w > most || (w == most && state < maj) {
+			maj, most = state, w
+		}
+	}
+	return maj
+}
+
+// Read reads the crosswalk file name
+func Read(name string) (*Crosswalk, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	rdr := csv.NewReader(f)
+	rdr.FieldsPerRecord = -1
+	hdr, err := rdr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("zip3: %s: %v", name, err)
+	}
+	cols := make(map[string]int)
+	for ind, c := range hdr {
+		cols[strings.ToLower(strings.TrimSpace(c))] = ind
+	}
+	// width is the # of digits of the zips in the file
+	width := 3
+	zipCol, ok := cols["zip3"]
+	if !ok {
+		if zipCol, ok = cols["zip"]; !ok {
+			return nil, fmt.Errorf("zip3: %s: no zip3 or zip column", name)
+		}
+		width = 5
+	}
+	stateCol, ok := cols["state"]
+	if !ok {
+		return nil, fmt.Errorf("zip3: %s: no state column", name)
+	}
+	weightCol, hasWeight := cols["weight"]
+
+	c := &Crosswalk{File: name, States: make(map[string]map[string]float64)}
+	for line := 2; ; line++ {
+		row, e := rdr.Read()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, fmt.Errorf("zip3: %s: %v", name, e)
+		}
+		if zipCol >= len(row) || stateCol >= len(row) {
+			return nil, fmt.Errorf("zip3: %s: line %d is short", name, line)
+		}
+		zip, state := strings.TrimSpace(row[zipCol]), strings.ToUpper(strings.TrimSpace(row[stateCol]))
+		// leading zeros are lost if the file passed through a spreadsheet
+		if zip != "" && len(zip) < width {
+			zip = strings.Repeat("0", width-len(zip)) + zip
+		}
+		if len(zip) != width || len(state) != 2 {
+			return nil, fmt.Errorf("zip3: %s: line %d: bad zip %s or state %s", name, line, zip, state)
+		}
+		w := 1.0
+		if hasWeight && weightCol < len(row) {
+			if w, e = strconv.ParseFloat(strings.TrimSpace(row[weightCol]), 64); e != nil {
+				return nil, fmt.Errorf("zip3: %s: line %d: bad weight %s", name, line, row[weightCol])
+			}
+		}
+		z3 := zip[:3]
+		if c.States[z3] == nil {
+			c.States[z3] = make(map[string]float64)
+		}
+		c.States[z3][state] += w
+	}
+	if len(c.States) == 0 {
+		return nil, fmt.Errorf("zip3: %s: no zip3s", name)
+	}
+	return c, nil
+}
@@ -0,0 +1,51 @@
+package zip3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"zip3.csv": "zip3,state,weight\n945,CA,10\n971,OR,8\n971,WA,2\n6,CT,3\n",
+		"zip.csv":  "ZIP,STATE\n97101,OR\n97102,OR\n97103,WA\n00601,PR\n",
+	}
+	for name, body := range files {
+		if e := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); e != nil {
+			t.Fatal(e)
+		}
+	}
+	for name := range files {
+		c, err := Read(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := c.Majority("97105"); m != "OR" {
+			t.Errorf("%s: majority of 971: got %s, want OR", name, m)
+		}
+		if !c.Has("97105", "WA") || c.Has("97105", "CA") {
+			t.Errorf("%s: states of 971: got %v", name, c.States["971"])
+		}
+		if m := c.Majority("12345"); m != "" {
+			t.Errorf("%s: majority of 123: got %s, want none", name, m)
+		}
+	}
+
+	c, err := Read(filepath.Join(dir, "zip3.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := c.Majority("006"); m != "CT" {
+		t.Errorf("majority of 006: got %s, want CT", m)
+	}
+
+	bad := filepath.Join(dir, "bad.csv")
+	if e := os.WriteFile(bad, []byte("zip3,st\n945,CA\n"), 0600); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := Read(bad); e == nil {
+		t.Error("expected error for no state column")
+	}
+}